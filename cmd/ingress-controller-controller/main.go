@@ -2,16 +2,26 @@ package main
 
 import (
 	"context"
+	"flag"
+	"os"
 	"runtime"
 	"time"
 
 	sdk "github.com/operator-framework/operator-sdk/pkg/sdk"
 	k8sutil "github.com/operator-framework/operator-sdk/pkg/util/k8sutil"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
+	cli "github.com/snarlysodboxer/ingress-controller-controller/pkg/cli"
+	manifests "github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
 	stub "github.com/snarlysodboxer/ingress-controller-controller/pkg/stub"
+	webhook "github.com/snarlysodboxer/ingress-controller-controller/pkg/webhook"
 
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/record"
 )
 
 func printVersion() {
@@ -21,6 +31,40 @@ func printVersion() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := cli.Commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		}
+	}
+
+	debounceWindow := flag.Duration("debounce-window", 2*time.Second, "How long to wait after a Service event for more events before reconciling")
+	retryBaseDelay := flag.Duration("retry-base-delay", time.Second, "Initial delay before retrying a failed reconcile loop")
+	retryMaxDelay := flag.Duration("retry-max-delay", 5*time.Minute, "Maximum delay between retries of a failed reconcile loop")
+	retryQPS := flag.Float64("retry-qps", 1, "Overall rate limit for retries of failed reconcile loops")
+	retryBurst := flag.Int("retry-burst", 5, "Burst allowed above -retry-qps")
+	reconcileInterval := flag.Duration("reconcile-interval", 5*time.Minute, "How often to reconcile every Ingress regardless of Service events, 0 to only do so at startup")
+	orphanGracePeriod := flag.Duration("orphan-grace-period", 5*time.Minute, "How long an Ingress must have had no Services contributing to it before it's deleted")
+	maxOrphanDeletions := flag.Int("max-orphan-deletions", 10, "Hold all deletions while more than this many Ingresses are orphaned at once, 0 to disable")
+	dryRun := flag.Bool("dry-run", false, "Make no changes, log a plan of the changes that would be made instead")
+	webhookAddress := flag.String("webhook-address", "", "Address to serve the admission webhooks on over HTTPS, such as :8443, empty to disable them")
+	webhookCertFile := flag.String("webhook-cert-file", "/etc/webhook/tls.crt", "TLS certificate for the admission webhooks")
+	webhookKeyFile := flag.String("webhook-key-file", "/etc/webhook/tls.key", "TLS key for the admission webhooks")
+	defaultIngressName := flag.String("default-ingress-name", "", "Ingress name the mutating webhook fills in for config annotations without one")
+	clusterName := flag.String("cluster-name", "", "Cluster name for {{.Cluster}} in host and path templates")
+	env := flag.String("env", "", "Environment name for {{.Env}} in host and path templates")
+	targetController := flag.String("target-controller", manifests.ControllerGCE, "Ingress controller to translate paths for, one of gce or nginx")
+	maxRulesPerIngress := flag.Int("max-rules-per-ingress", 0, "Split Ingresses with more host rules than this into shards, 0 for no limit")
+	flag.Parse()
+	build := manifests.Options{
+		TargetController:   *targetController,
+		TemplateValues:     manifests.TemplateValues{Cluster: *clusterName, Env: *env},
+		MaxRulesPerIngress: *maxRulesPerIngress,
+	}
+	err := build.Validate()
+	if err != nil {
+		logrus.Fatalf("Invalid flags: %v", err)
+	}
+
 	logrus.SetLevel(logrus.DebugLevel) // TODO make this configurable
 	printVersion()
 
@@ -29,21 +73,57 @@ func main() {
 	if err != nil {
 		logrus.Errorf("failed to register operator specific metrics: %v", err)
 	}
-	handler := stub.NewHandler(metrics)
 
-	resource := "v1"
-	kind := "Service"
 	namespace, err := k8sutil.GetWatchNamespace()
 	if err != nil {
 		logrus.Fatalf("Failed to get watch namespace: %v", err)
 	}
+	// Ingresses, their owners and backends all live in the namespace watched, so it can't be every namespace
+	if namespace == "" {
+		logrus.Fatalf("WATCH_NAMESPACE must be set to a single namespace")
+	}
 	resyncPeriod := time.Duration(20) * time.Second // TODO make this configurable
 
 	selector := "icc-operator=true" // TODO make this configurable
-	watchOption := sdk.WithLabelSelector(selector)
-	logrus.Infof("Watching %s, %s, %s, %d, %s", resource, kind, namespace, resyncPeriod, selector)
-	sdk.Watch(resource, kind, namespace, resyncPeriod, watchOption)
 
-	sdk.Handle(handler)
-	sdk.Run(context.TODO())
+	client := k8sutil.GetKubeClient()
+	logrus.Infof("Watching Services in %s with %s, resyncing every %s", namespace, selector, resyncPeriod)
+	caches := stub.NewCaches(client, namespace, selector, resyncPeriod)
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events(namespace)})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "ingress-controller-controller"})
+	handler := stub.NewHandler(metrics, stub.Options{
+		DebounceWindow:     *debounceWindow,
+		RetryBaseDelay:     *retryBaseDelay,
+		RetryMaxDelay:      *retryMaxDelay,
+		QPS:                *retryQPS,
+		Burst:              *retryBurst,
+		ReconcileInterval:  *reconcileInterval,
+		OrphanGracePeriod:  *orphanGracePeriod,
+		MaxOrphanDeletions: *maxOrphanDeletions,
+		DryRun:             *dryRun,
+		Recorder:           recorder,
+		Build:              build,
+	}, caches)
+
+	// the Service cache's informer drives reconciles, rather than a separate sdk.Watch
+	ctx := context.TODO()
+	handler.Start(ctx)
+	if *webhookAddress != "" {
+		labelSelector, err := labels.Parse(selector)
+		if err != nil {
+			logrus.Fatalf("Invalid Service selector: %v", err)
+		}
+		server := webhook.NewServer(caches.Services, caches.HostPolicies, webhook.Options{
+			DefaultIngressName: *defaultIngressName,
+			Namespace:          namespace,
+			Selector:           labelSelector,
+			Build:              build,
+		})
+		go func() {
+			logrus.Fatalf("Failed to serve admission webhooks: %v", server.ListenAndServeTLS(*webhookAddress, *webhookCertFile, *webhookKeyFile))
+		}()
+	}
+	<-ctx.Done()
 }
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+)
+
+// Command runs a subcommand with the arguments after its name, returning the exit code
+type Command func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+// Commands are the subcommands that run offline, without a cluster
+var Commands = map[string]Command{
+	"conflicts": Conflicts,
+	"diff":      Diff,
+	"lint":      Lint,
+	"render":    Render,
+}
+
+// optionFlags adds the flags for how the controller builds `Ingress`s, such as the values host and path templates are rendered with
+// and the ingress controller paths are translated for, returning a func that reads them once the flags are parsed
+func optionFlags(flags *flag.FlagSet) func() (error, manifests.Options) {
+	cluster := flags.String("cluster-name", "", "Cluster name for {{.Cluster}} in host and path templates")
+	env := flags.String("env", "", "Environment name for {{.Env}} in host and path templates")
+	controller := flags.String("target-controller", manifests.ControllerGCE, "Ingress controller to translate paths for, one of gce or nginx")
+	maxRules := flags.Int("max-rules-per-ingress", 0, "Split Ingresses with more host rules than this into shards, 0 for no limit")
+
+	return func() (error, manifests.Options) {
+		options := manifests.Options{
+			TargetController:   *controller,
+			TemplateValues:     manifests.TemplateValues{Cluster: *cluster, Env: *env},
+			MaxRulesPerIngress: *maxRules,
+		}
+		return options.Validate(), options
+	}
+}
+
+// policyFlag adds the flag for the host policy `ConfigMap` to check routes against, as the controller does,
+// returning its path, and a func that reads it once the flags are parsed, which allows everything when the flag isn't set
+func policyFlag(flags *flag.FlagSet) (*string, func(stdin io.Reader) (error, manifests.HostPolicy)) {
+	path := flags.String("host-policy", "", "File holding the host policy ConfigMap to check routes against, - for stdin, none allows everything")
+
+	return path, func(stdin io.Reader) (error, manifests.HostPolicy) {
+		if *path == "" {
+			return nil, manifests.HostPolicy{}
+		}
+		err, loaded := loadManifests([]string{*path}, stdin)
+		if err != nil {
+			return err, manifests.HostPolicy{}
+		}
+		if loaded.HostPolicy == nil {
+			return fmt.Errorf("%s: no ConfigMap '%s'", *path, manifests.HostPolicyName), manifests.HostPolicy{}
+		}
+		return manifests.ParseHostPolicy(*loaded.HostPolicy)
+	}
+}
+
+// checkStdin makes sure at most one of the inputs, by flag name, reads stdin,
+// since the first to read it would leave nothing for the others
+func checkStdin(inputs map[string][]string) error {
+	readers := []string{}
+	for name, paths := range inputs {
+		for _, path := range paths {
+			if path == "-" {
+				readers = append(readers, "-"+name)
+			}
+		}
+	}
+	if len(readers) > 1 {
+		sort.Strings(readers)
+		return fmt.Errorf("only one input may read stdin, got %s", strings.Join(readers, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+)
+
+// conflictFinding is a conflict and the files the two `Service`s were read from
+type conflictFinding struct {
+	File        string `json:"file"`
+	ClaimedFile string `json:"claimedFile"`
+	manifests.Conflict
+}
+
+// violationFinding is a host policy violation and the file the `Service` was read from
+type violationFinding struct {
+	File string `json:"file"`
+	manifests.Violation
+}
+
+// conflictReport is everything the controller would reject from the merged manifests
+type conflictReport struct {
+	Violations []violationFinding `json:"violations"`
+	Conflicts  []conflictFinding  `json:"conflicts"`
+	Limits     []string           `json:"limits"`
+}
+
+// Conflicts merges the `Service` manifests from every path given, such as one per repo, and reports
+// the routes the controller would reject as breaking the host policy given or already claimed, and the `Ingress`s it would reject as too big,
+// exiting nonzero when there are any
+func Conflicts(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("conflicts", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	paths := pathFlag{}
+	flags.Var(&paths, "f", "Service manifest file or directory to read, - for stdin, may be repeated")
+	output := flags.String("o", "text", "Output format, one of text or json")
+	readOptions := optionFlags(flags)
+	policyPath, readPolicy := policyFlag(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	err, options := readOptions()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "Unknown output format '%s'\n", *output)
+		return 2
+	}
+	if len(paths) == 0 {
+		paths = append(paths, "-")
+	}
+	err = checkStdin(map[string][]string{"f": paths, "host-policy": {*policyPath}})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	err, policy := readPolicy(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading host policy: %v\n", err)
+		return 1
+	}
+
+	err, loaded := loadManifests(paths, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading manifests: %v\n", err)
+		return 1
+	}
+
+	err, report := findConflicts(loaded, policy, options)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error building ingress configs: %v\n", err)
+		return 1
+	}
+	if *output == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error writing report: %v\n", err)
+			return 1
+		}
+	} else {
+		for _, finding := range report.Violations {
+			fmt.Fprintf(stdout, "%s: %v\n", finding.File, finding.Violation)
+		}
+		for _, finding := range report.Conflicts {
+			fmt.Fprintf(stdout, "%s: %v (%s)\n", finding.File, finding.Conflict, finding.ClaimedFile)
+		}
+		for _, limit := range report.Limits {
+			fmt.Fprintln(stdout, limit)
+		}
+	}
+	if len(report.Violations) > 0 || len(report.Conflicts) > 0 || len(report.Limits) > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// findConflicts checks the loaded `Service`s the way the controller does, ignoring invalid annotations, which lint reports
+func findConflicts(loaded loadedManifests, policy manifests.HostPolicy, options manifests.Options) (error, conflictReport) {
+	report := conflictReport{Violations: []violationFinding{}, Conflicts: []conflictFinding{}, Limits: []string{}}
+	sources := map[string]string{}
+	for i, service := range loaded.Services.Items {
+		sources[service.ObjectMeta.Namespace+"/"+service.ObjectMeta.Name] = loaded.ServiceSources[i]
+	}
+
+	valid, _ := manifests.ValidServices(manifests.GetAnnotatedServices(loaded.Services), options)
+	violations := manifests.CheckHostPolicy(valid, policy, options)
+	for _, violation := range violations {
+		report.Violations = append(report.Violations, violationFinding{
+			File:      sources[violation.Namespace+"/"+violation.Service],
+			Violation: violation,
+		})
+	}
+	conflicts := manifests.FindConflicts(valid, violations, options)
+	for _, conflict := range conflicts {
+		report.Conflicts = append(report.Conflicts, conflictFinding{
+			File:        sources[conflict.Namespace+"/"+conflict.Service],
+			ClaimedFile: sources[conflict.ClaimedNamespace+"/"+conflict.ClaimedService],
+			Conflict:    conflict,
+		})
+	}
+
+	err, configs := manifests.BuildConfigs(manifests.ExcludeConflicts(valid, conflicts), violations, options)
+	if err != nil {
+		return err, conflictReport{}
+	}
+	for _, ingress := range manifests.ShardIngresses(manifests.NewIngressList(configs, options), loaded.Ingresses, options).Items {
+		err = manifests.CheckLimits(ingress)
+		if err != nil {
+			report.Limits = append(report.Limits, err.Error())
+		}
+	}
+
+	return nil, report
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const hostPolicyYAML = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ingress-controller-controller-host-policy
+data:
+  policy.yaml: |
+    rules:
+    - host: that.example.com
+      namespaces: [other]
+`
+
+const otherRepoYAML = `apiVersion: v1
+kind: Service
+metadata:
+  name: webcopy
+  annotations:
+    ingress-controller-controller.alpha.davidamick.com/config: |
+      name: production
+      host: that.example.com
+      path: /that
+      service: webcopy
+      port: 80
+`
+
+// another repo moving the staging Ingress to another class
+const classRepoYAML = `apiVersion: v1
+kind: Service
+metadata:
+  name: x-class
+  annotations:
+    ingress-controller-controller.alpha.davidamick.com/config: |
+      apiVersion: v1beta1
+      ingress: staging
+      host: x-class.example.com
+      ingressClass: internal
+      backend:
+        service: x-class
+        port: 80
+`
+
+func TestConflicts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conflicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := writeTempFile(t, dir, "repo.yaml", servicesYAML)
+	otherRepo := writeTempFile(t, dir, "other-repo.yaml", otherRepoYAML)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := Conflicts([]string{"-f", repo}, nil, stdout, stderr)
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d:\n%s%s", code, stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	code = Conflicts([]string{"-f", repo, "-f", otherRepo}, nil, stdout, stderr)
+	if code != 1 {
+		t.Errorf("Expected exit code 1, got %d: %s", code, stderr.String())
+	}
+	expected := otherRepo + ": default/webcopy: host 'that.example.com' path '/that' for Ingress 'production' is already routed by default/web for Ingress 'staging' (" + repo + ")\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, stdout.String())
+	}
+
+	stdout.Reset()
+	Conflicts([]string{"-o", "json", "-f", repo, "-f", otherRepo}, nil, stdout, stderr)
+	report := conflictReport{}
+	err = json.Unmarshal(stdout.Bytes(), &report)
+	if err != nil {
+		t.Fatalf("Expected JSON output, got %v:\n%v", err, stdout.String())
+	}
+	if len(report.Conflicts) != 1 || report.Conflicts[0].ClaimedFile != repo || report.Conflicts[0].Service != "webcopy" {
+		t.Errorf("Unexpected report: %v", report)
+	}
+
+	// rendering skips the same routes
+	stdout.Reset()
+	stderr.Reset()
+	Render([]string{"-f", repo, "-f", otherRepo}, nil, stdout, stderr)
+	if strings.Contains(stdout.String(), "webcopy") || !strings.Contains(stderr.String(), "already routed") {
+		t.Errorf("Expected the conflicting route to be skipped, got:\n%s%s", stdout.String(), stderr.String())
+	}
+
+	// so are Ingress settings differing from the oldest Service's
+	classRepo := writeTempFile(t, dir, "class-repo.yaml", classRepoYAML)
+	stdout.Reset()
+	code = Conflicts([]string{"-f", repo, "-f", classRepo}, nil, stdout, stderr)
+	if code != 1 || !strings.Contains(stdout.String(), classRepo+": default/x-class: class 'internal' for Ingress 'staging' differs from none set by default/") {
+		t.Errorf("Expected a class conflict, got %d:\n%s", code, stdout.String())
+	}
+
+	// routes breaking the host policy are reported, and take no part in conflicts
+	policy := writeTempFile(t, dir, "policy.yaml", hostPolicyYAML)
+	stdout.Reset()
+	stderr.Reset()
+	code = Conflicts([]string{"-host-policy", policy, "-f", repo, "-f", otherRepo}, nil, stdout, stderr)
+	if code != 1 || !strings.Contains(stdout.String(), otherRepo+": default/webcopy: host 'that.example.com' for Ingress 'production' isn't allowed for namespace 'default'") || strings.Contains(stdout.String(), "already routed") {
+		t.Errorf("Expected host policy violations and no conflicts, got %d:\n%s%s", code, stdout.String(), stderr.String())
+	}
+	stdout.Reset()
+	stderr.Reset()
+	Render([]string{"-host-policy", policy, "-f", repo}, nil, stdout, stderr)
+	if strings.Contains(stdout.String(), "that.example.com") || !strings.Contains(stderr.String(), "isn't allowed") {
+		t.Errorf("Expected the routes breaking the host policy to be skipped, got:\n%s%s", stdout.String(), stderr.String())
+	}
+	stderr.Reset()
+	if code := Conflicts([]string{"-host-policy", repo, "-f", repo}, nil, stdout, stderr); code != 1 || !strings.Contains(stderr.String(), "no ConfigMap") {
+		t.Errorf("Expected an error for a file without the host policy, got %d: %s", code, stderr.String())
+	}
+}
@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// Diff prints a unified diff for each `Ingress` the controller would create, update or delete
+// if the `Service` manifests given were applied to the cluster dumped with `kubectl get -o yaml`.
+// Like diff(1), it exits 0 when nothing would change, 1 when something would, and 2 on errors
+func Diff(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	paths := pathFlag{}
+	flags.Var(&paths, "f", "Service manifest file or directory to read, - for stdin, may be repeated")
+	clusterPaths := pathFlag{}
+	flags.Var(&clusterPaths, "cluster", "File or directory of the cluster's current Services and Ingresses, may be repeated")
+	readOptions := optionFlags(flags)
+	policyPath, readPolicy := policyFlag(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	err, options := readOptions()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if len(clusterPaths) == 0 {
+		fmt.Fprintln(stderr, "-cluster is required")
+		return 2
+	}
+	if len(paths) == 0 {
+		paths = append(paths, "-")
+	}
+	err = checkStdin(map[string][]string{"f": paths, "cluster": clusterPaths, "host-policy": {*policyPath}})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	err, policy := readPolicy(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading host policy: %v\n", err)
+		return 2
+	}
+
+	err, local := loadManifests(paths, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading manifests: %v\n", err)
+		return 2
+	}
+	err, cluster := loadManifests(clusterPaths, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading cluster dump: %v\n", err)
+		return 2
+	}
+
+	merged := newManifests()
+	merged.Services = mergeServices(cluster.Services, local.Services)
+	// so that hosts stay in the shards they're in
+	merged.Ingresses = cluster.Ingresses
+	err, desired, skipped := renderIngresses(merged, policy, options)
+	for _, reason := range skipped {
+		fmt.Fprintf(stderr, "Skipping config annotation: %v\n", reason)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error building ingress configs: %v\n", err)
+		return 2
+	}
+
+	err, diffs := diffIngresses(desired, cluster.Ingresses)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error diffing Ingresses: %v\n", err)
+		return 2
+	}
+	for _, diff := range diffs {
+		fmt.Fprint(stdout, diff)
+	}
+	if len(diffs) > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// mergeServices replaces the observed `Service`s with the local ones of the same namespace and name,
+// and adds the rest of the local ones
+func mergeServices(observed, local corev1.ServiceList) corev1.ServiceList {
+	merged := corev1.ServiceList{TypeMeta: observed.TypeMeta}
+	replaced := map[string]bool{}
+	for _, service := range local.Items {
+		replaced[service.ObjectMeta.Namespace+"/"+service.ObjectMeta.Name] = true
+	}
+	for _, service := range observed.Items {
+		if !replaced[service.ObjectMeta.Namespace+"/"+service.ObjectMeta.Name] {
+			merged.Items = append(merged.Items, service)
+		}
+	}
+	merged.Items = append(merged.Items, local.Items...)
+
+	return merged
+}
+
+// diffIngresses compares the desired `Ingress`s with the observed ones the way the controller does,
+// returning a unified diff for each that would change. Updates only show the fields we manage
+func diffIngresses(desired, observed v1beta1.IngressList) (error, []string) {
+	diffs := []string{}
+	managed := manifests.GetAnnotatedIngresses(observed)
+	for _, ingress := range desired.Items {
+		name := "Ingress/" + ingress.Name
+		existing, found := manifests.FindIngress(managed, ingress.Name)
+		if !found {
+			err, after := manifests.ToYAML(ingress)
+			if err != nil {
+				return err, []string{}
+			}
+			diffs = append(diffs, manifests.UnifiedDiff("/dev/null", name, "", after))
+			continue
+		}
+		if !manifests.IngressChanged(ingress, existing) {
+			continue
+		}
+
+		updated := *existing.DeepCopy()
+		updated.Spec = ingress.Spec
+		if updated.ObjectMeta.Annotations == nil {
+			updated.ObjectMeta.Annotations = map[string]string{}
+		}
+		for key, value := range ingress.ObjectMeta.Annotations {
+			updated.ObjectMeta.Annotations[key] = value
+		}
+		err, before := manifests.ToYAML(existing)
+		if err != nil {
+			return err, []string{}
+		}
+		err, after := manifests.ToYAML(updated)
+		if err != nil {
+			return err, []string{}
+		}
+		diff := manifests.UnifiedDiff(name, name, before, after)
+		if diff != "" {
+			diffs = append(diffs, diff)
+		}
+	}
+
+	for _, orphan := range manifests.GetOrphanedIngresses(desired, managed).Items {
+		if manifests.IsPinnedIngress(orphan) {
+			continue
+		}
+		err, before := manifests.ToYAML(orphan)
+		if err != nil {
+			return err, []string{}
+		}
+		diffs = append(diffs, manifests.UnifiedDiff("Ingress/"+orphan.Name, "/dev/null", before, ""))
+	}
+
+	return nil, diffs
+}
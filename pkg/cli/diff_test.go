@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const clusterYAML = `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: web
+    namespace: default
+    annotations:
+      ingress-controller-controller.alpha.davidamick.com/config: |
+        name: staging
+        host: that.example.com
+        path: /old
+        service: web
+        port: 80
+  spec:
+    ports:
+    - port: 80
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: legacy
+    namespace: default
+    annotations:
+      ingress-controller-controller.alpha.davidamick.com/config: |
+        name: legacy
+        host: legacy.example.com
+        service: legacy
+        port: 80
+  spec:
+    ports:
+    - port: 80
+- apiVersion: extensions/v1beta1
+  kind: Ingress
+  metadata:
+    name: staging
+    namespace: default
+    resourceVersion: "42"
+    annotations:
+      ingress-controller-controller.alpha.davidamick.com/managed: "true"
+  spec:
+    rules:
+    - host: that.example.com
+      http:
+        paths:
+        - backend:
+            serviceName: web
+            servicePort: 80
+          path: /old
+- apiVersion: extensions/v1beta1
+  kind: Ingress
+  metadata:
+    name: legacy
+    namespace: default
+    annotations:
+      ingress-controller-controller.alpha.davidamick.com/managed: "true"
+  spec:
+    rules:
+    - host: legacy.example.com
+      http:
+        paths:
+        - backend:
+            serviceName: legacy
+            servicePort: 80
+`
+
+const legacyRemovedYAML = `apiVersion: v1
+kind: Service
+metadata:
+  name: legacy
+`
+
+func writeTempFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cluster := writeTempFile(t, dir, "cluster.yaml", clusterYAML)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := Diff([]string{"-cluster", cluster, "-f", writeTempFile(t, dir, "empty.yaml", "")}, nil, stdout, stderr)
+	if code != 0 {
+		t.Errorf("Expected exit code 0 with no local changes, got %d:\n%s%s", code, stdout.String(), stderr.String())
+	}
+
+	local := writeTempFile(t, dir, "local.yaml", servicesYAML+"---\n"+legacyRemovedYAML)
+	stdout.Reset()
+	code = Diff([]string{"-cluster", cluster, "-f", local}, nil, stdout, stderr)
+	if code != 1 {
+		t.Errorf("Expected exit code 1, got %d: %s", code, stderr.String())
+	}
+	result := stdout.String()
+	for _, expected := range []string{
+		"--- Ingress/staging\n+++ Ingress/staging\n",
+		"-        path: /old\n",
+		"+        path: /that\n",
+		"+        path: /other\n",
+		"--- Ingress/legacy\n+++ /dev/null\n",
+	} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("Expected output to contain %q, got:\n%v", expected, result)
+		}
+	}
+	if strings.Contains(result, "resourceVersion") {
+		t.Errorf("Expected only changed fields in the diff, got:\n%v", result)
+	}
+}
+
+func TestDiffErrors(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := Diff([]string{}, strings.NewReader(servicesYAML), stdout, stderr)
+	if code != 2 {
+		t.Errorf("Expected exit code 2 without -cluster, got %d", code)
+	}
+
+	code = Diff([]string{"-cluster", "does-not-exist.yaml"}, strings.NewReader(servicesYAML), stdout, stderr)
+	if code != 2 {
+		t.Errorf("Expected exit code 2 for a missing file, got %d", code)
+	}
+
+	// -f defaults to stdin too
+	code = Diff([]string{"-cluster", "-"}, strings.NewReader(servicesYAML), stdout, stderr)
+	if code != 2 {
+		t.Errorf("Expected exit code 2 for two inputs reading stdin, got %d", code)
+	}
+}
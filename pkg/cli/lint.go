@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+)
+
+// finding is a problem with a `Service` and the file it was read from
+type finding struct {
+	File string `json:"file"`
+	manifests.Problem
+}
+
+// Lint checks the config annotations of the `Service` manifests given the way the controller does,
+// and that their backends are among them, exiting nonzero when there are problems
+func Lint(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	paths := pathFlag{}
+	flags.Var(&paths, "f", "Service manifest file or directory to read, - for stdin, may be repeated")
+	output := flags.String("o", "text", "Output format, one of text, json or github")
+	checkBackends := flags.Bool("check-backends", true, "Check that the Service and port each annotation routes to are in the manifests given")
+	readOptions := optionFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	err, options := readOptions()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if *output != "text" && *output != "json" && *output != "github" {
+		fmt.Fprintf(stderr, "Unknown output format '%s'\n", *output)
+		return 2
+	}
+	if len(paths) == 0 {
+		paths = append(paths, "-")
+	}
+
+	err, loaded := loadManifests(paths, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading manifests: %v\n", err)
+		return 1
+	}
+
+	for i, service := range loaded.Services.Items {
+		if warning, deprecated := manifests.DeprecatedConfig(service); deprecated {
+			fmt.Fprintf(stderr, "Warning: %s: %s/%s: %s\n", loaded.ServiceSources[i], service.Namespace, service.Name, warning)
+		}
+	}
+	findings := lint(loaded, *checkBackends, options)
+	err = printFindings(stdout, *output, findings)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error writing findings: %v\n", err)
+		return 1
+	}
+	if len(findings) > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func lint(loaded loadedManifests, checkBackends bool, options manifests.Options) []finding {
+	findings := []finding{}
+	for i, service := range loaded.Services.Items {
+		problems := manifests.ValidateService(service, options)
+		if len(problems) == 0 && checkBackends {
+			problems = manifests.ValidateBackend(service, loaded.Services, options)
+		}
+		for _, problem := range problems {
+			findings = append(findings, finding{File: loaded.ServiceSources[i], Problem: problem})
+		}
+	}
+
+	return findings
+}
+
+func printFindings(writer io.Writer, output string, findings []finding) error {
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(findings)
+	case "github":
+		// workflow commands, which GitHub Actions shows as annotations on the file
+		for _, f := range findings {
+			_, err := fmt.Fprintf(writer, "::error file=%s,title=%s::%s\n", githubEscape(f.File, true), githubEscape(f.Field, true), githubEscape(f.Problem.Error(), false))
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		for _, f := range findings {
+			_, err := fmt.Fprintf(writer, "%s: %v\n", f.File, f.Problem)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// githubEscape escapes a workflow command value or, when property is true, a property
+func githubEscape(value string, property bool) string {
+	replacer := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	if property {
+		replacer = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+	}
+
+	return replacer.Replace(value)
+}
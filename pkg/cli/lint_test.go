@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const invalidServicesYAML = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  annotations:
+    ingress-controller-controller.alpha.davidamick.com/config: |
+      name: staging
+      host: that.example.com
+      path: that
+      service: web
+      port: 80
+spec:
+  ports:
+  - port: 80
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web2
+  annotations:
+    ingress-controller-controller.alpha.davidamick.com/config: |
+      name: staging
+      host: that.example.com
+      path: /other
+      service: web3
+      port: 80
+spec:
+  ports:
+  - port: 80
+`
+
+func TestLint(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := Lint([]string{}, strings.NewReader(servicesYAML), stdout, stderr)
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	// the fixtures have no apiVersion
+	if !strings.Contains(stderr.String(), "Warning: <stdin>: default/web: Config annotation version v1alpha1 is deprecated") {
+		t.Errorf("Expected a deprecation warning, got:\n%s", stderr.String())
+	}
+
+	stdout.Reset()
+	code = Lint([]string{}, strings.NewReader(invalidServicesYAML), stdout, stderr)
+	if code != 1 {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+	expected := `<stdin>: default/web: path: must begin with '/'
+<stdin>: default/web2: service: Service 'web3' not found
+`
+	if stdout.String() != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, stdout.String())
+	}
+
+	stdout.Reset()
+	code = Lint([]string{"-check-backends=false"}, strings.NewReader(invalidServicesYAML), stdout, stderr)
+	if code != 1 || strings.Contains(stdout.String(), "web3") {
+		t.Errorf("Expected only the path problem, got %d:\n%v", code, stdout.String())
+	}
+}
+
+func TestLintOutputs(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	Lint([]string{"-o", "json"}, strings.NewReader(invalidServicesYAML), stdout, stderr)
+	findings := []finding{}
+	err := json.Unmarshal(stdout.Bytes(), &findings)
+	if err != nil {
+		t.Fatalf("Expected JSON output, got %v:\n%v", err, stdout.String())
+	}
+	if len(findings) != 2 || findings[0].File != "<stdin>" || findings[0].Field != "path" {
+		t.Errorf("Unexpected findings: %v", findings)
+	}
+
+	stdout.Reset()
+	Lint([]string{"-o", "github"}, strings.NewReader(invalidServicesYAML), stdout, stderr)
+	expected := "::error file=<stdin>,title=path::default/web: path: must begin with '/'\n"
+	if !strings.HasPrefix(stdout.String(), expected) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, stdout.String())
+	}
+
+	code := Lint([]string{"-o", "xml"}, strings.NewReader(invalidServicesYAML), stdout, stderr)
+	if code != 2 {
+		t.Errorf("Expected exit code 2 for an unknown format, got %d", code)
+	}
+}
+
+func TestLintExamples(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := Lint([]string{"-f", "../../examples/4svcs-2ingresses.yml"}, nil, stdout, stderr)
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d:\n%s%s", code, stdout.String(), stderr.String())
+	}
+}
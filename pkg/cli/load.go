@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// loadedManifests are the objects read from files, or from stdin when the path is "-"
+type loadedManifests struct {
+	Services corev1.ServiceList
+	// the file each `Service` was read from
+	ServiceSources []string
+	Ingresses      v1beta1.IngressList
+	// the host policy `ConfigMap`, if one was read
+	HostPolicy *corev1.ConfigMap
+}
+
+// pathFlag collects repeated -f flags
+type pathFlag []string
+
+func (p *pathFlag) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathFlag) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+func newManifests() loadedManifests {
+	return loadedManifests{
+		Services: corev1.ServiceList{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Service",
+				APIVersion: "v1",
+			},
+		},
+		ServiceSources: []string{},
+		Ingresses: v1beta1.IngressList{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Ingress",
+				APIVersion: "extensions/v1beta1",
+			},
+		},
+	}
+}
+
+// loadManifests reads `Service`s, `Ingress`s and the host policy `ConfigMap` from YAML or JSON files, including `List`s,
+// directories are walked for .yaml, .yml and .json files, and other kinds are skipped
+func loadManifests(paths []string, stdin io.Reader) (error, loadedManifests) {
+	loaded := newManifests()
+	for _, path := range paths {
+		if path == "-" {
+			err := loaded.read("<stdin>", stdin)
+			if err != nil {
+				return err, loadedManifests{}
+			}
+			continue
+		}
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			extension := filepath.Ext(file)
+			if file != path && extension != ".yaml" && extension != ".yml" && extension != ".json" {
+				return nil
+			}
+			reader, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			return loaded.read(file, reader)
+		})
+		if err != nil {
+			return err, loadedManifests{}
+		}
+	}
+
+	return nil, loaded
+}
+
+func (m *loadedManifests) read(source string, reader io.Reader) error {
+	decoder := yaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		object := map[string]interface{}{}
+		err := decoder.Decode(&object)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", source, err)
+		}
+		err = m.add(source, object)
+		if err != nil {
+			return fmt.Errorf("%s: %v", source, err)
+		}
+	}
+}
+
+func (m *loadedManifests) add(source string, object map[string]interface{}) error {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	switch object["kind"] {
+	case "List", "ServiceList", "IngressList":
+		list := struct {
+			Items []map[string]interface{} `json:"items"`
+		}{}
+		err = json.Unmarshal(raw, &list)
+		if err != nil {
+			return err
+		}
+		for _, item := range list.Items {
+			err = m.add(source, item)
+			if err != nil {
+				return err
+			}
+		}
+	case "Service":
+		service := corev1.Service{}
+		err = json.Unmarshal(raw, &service)
+		if err != nil {
+			return err
+		}
+		if service.ObjectMeta.Namespace == "" {
+			service.ObjectMeta.Namespace = "default"
+		}
+		m.Services.Items = append(m.Services.Items, service)
+		m.ServiceSources = append(m.ServiceSources, source)
+	case "Ingress":
+		ingress := v1beta1.Ingress{}
+		err = json.Unmarshal(raw, &ingress)
+		if err != nil {
+			return err
+		}
+		if ingress.ObjectMeta.Namespace == "" {
+			ingress.ObjectMeta.Namespace = "default"
+		}
+		m.Ingresses.Items = append(m.Ingresses.Items, ingress)
+	case "ConfigMap":
+		configMap := corev1.ConfigMap{}
+		err = json.Unmarshal(raw, &configMap)
+		if err != nil {
+			return err
+		}
+		if configMap.ObjectMeta.Name == manifests.HostPolicyName {
+			m.HostPolicy = &configMap
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	"k8s.io/api/extensions/v1beta1"
+)
+
+// Render prints the `Ingress`s the controller would build from the `Service` manifests given, as YAML
+func Render(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	paths := pathFlag{}
+	flags.Var(&paths, "f", "Service manifest file or directory to read, - for stdin, may be repeated")
+	readOptions := optionFlags(flags)
+	policyPath, readPolicy := policyFlag(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	err, options := readOptions()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if len(paths) == 0 {
+		paths = append(paths, "-")
+	}
+	err = checkStdin(map[string][]string{"f": paths, "host-policy": {*policyPath}})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	err, policy := readPolicy(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading host policy: %v\n", err)
+		return 1
+	}
+
+	err, loaded := loadManifests(paths, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading manifests: %v\n", err)
+		return 1
+	}
+
+	err, ingresses, skipped := renderIngresses(loaded, policy, options)
+	for _, reason := range skipped {
+		fmt.Fprintf(stderr, "Skipping config annotation: %v\n", reason)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error building ingress configs: %v\n", err)
+		return 1
+	}
+	for i, ingress := range ingresses.Items {
+		err, out := manifests.ToYAML(ingress)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error rendering Ingress '%s': %v\n", ingress.Name, err)
+			return 1
+		}
+		if i > 0 {
+			fmt.Fprintln(stdout, "---")
+		}
+		fmt.Fprint(stdout, out)
+	}
+
+	return 0
+}
+
+// renderIngresses builds the `Ingress`s from the loaded `Service`s, the same way the controller does,
+// skipping and returning the `Service`s with problems or conflicts, and the routes breaking the host policy
+func renderIngresses(loaded loadedManifests, policy manifests.HostPolicy, options manifests.Options) (error, v1beta1.IngressList, []error) {
+	skipped := []error{}
+	annotated := manifests.GetAnnotatedServices(loaded.Services)
+	valid, problems := manifests.ValidServices(annotated, options)
+	for _, problem := range problems {
+		skipped = append(skipped, problem)
+	}
+	violations := manifests.CheckHostPolicy(valid, policy, options)
+	for _, violation := range violations {
+		skipped = append(skipped, violation)
+	}
+	conflicts := manifests.FindConflicts(valid, violations, options)
+	for _, conflict := range conflicts {
+		skipped = append(skipped, conflict)
+	}
+	err, configs := manifests.BuildConfigs(manifests.ExcludeConflicts(valid, conflicts), violations, options)
+	if err != nil {
+		return err, v1beta1.IngressList{}, skipped
+	}
+
+	return nil, manifests.ShardIngresses(manifests.NewIngressList(configs, options), loaded.Ingresses, options), skipped
+}
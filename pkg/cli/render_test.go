@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const servicesYAML = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  annotations:
+    ingress-controller-controller.alpha.davidamick.com/config: |
+      name: staging
+      host: that.example.com
+      path: /that
+      service: web
+      port: 80
+spec:
+  ports:
+  - port: 80
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: web2
+    annotations:
+      ingress-controller-controller.alpha.davidamick.com/config: |
+        name: staging
+        host: that.example.com
+        path: /other
+        service: web2
+        port: 80
+  spec:
+    ports:
+    - port: 80
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: ignored
+`
+
+func TestRender(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := Render([]string{}, strings.NewReader(servicesYAML), stdout, stderr)
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	expected := `apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  annotations:
+    ingress-controller-controller.alpha.davidamick.com/managed: "true"
+  name: staging
+  namespace: default
+spec:
+  rules:
+  - host: that.example.com
+    http:
+      paths:
+      - backend:
+          serviceName: web
+          servicePort: 80
+        path: /that
+      - backend:
+          serviceName: web2
+          servicePort: 80
+        path: /other
+status:
+  loadBalancer: {}
+`
+	// depending on the apimachinery version, empty timestamps are rendered as null
+	result := strings.Replace(stdout.String(), "  creationTimestamp: null\n", "", -1)
+	if result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestRenderExamples(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := Render([]string{"-f", "../../examples/4svcs-2ingresses.yml"}, nil, stdout, stderr)
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if strings.Count(stdout.String(), "kind: Ingress") != 2 {
+		t.Errorf("Expected 2 Ingresses, got:\n%v", stdout.String())
+	}
+	for _, expected := range []string{"name: primary-ingress", "name: secondary-ingress", "serviceName: my-fourth-service"} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("Expected output to contain %q, got:\n%v", expected, stdout.String())
+		}
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := Render([]string{"-f", "does-not-exist.yaml"}, nil, stdout, stderr)
+	if code != 1 {
+		t.Errorf("Expected exit code 1 for a missing file, got %d", code)
+	}
+
+	code = Render([]string{"-bogus"}, nil, stdout, stderr)
+	if code != 2 {
+		t.Errorf("Expected exit code 2 for a bad flag, got %d", code)
+	}
+
+	// -f defaults to stdin too, which would be left with nothing to render
+	stderr.Reset()
+	code = Render([]string{"-host-policy", "-"}, strings.NewReader(servicesYAML), stdout, stderr)
+	if code != 2 || !strings.Contains(stderr.String(), "only one input may read stdin, got -f, -host-policy") {
+		t.Errorf("Expected exit code 2 for two inputs reading stdin, got %d: %s", code, stderr.String())
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	services := strings.Replace(servicesYAML, "host: that.example.com", "host: that.{{.Env}}.example.com", -1)
+	code := Render([]string{"-env", "qa"}, strings.NewReader(services), stdout, stderr)
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "- host: that.qa.example.com\n") {
+		t.Errorf("Expected the host to be rendered, got:\n%v", stdout.String())
+	}
+}
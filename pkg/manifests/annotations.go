@@ -0,0 +1,196 @@
+package manifests
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// annotationPrefix starts all of our annotations
+const annotationPrefix = "ingress-controller-controller.alpha.davidamick.com/"
+
+// fieldAnnotations are the per-field alternatives to the config annotation, by field,
+// each of which may also be prefixed with a route index such as `0.`
+var fieldAnnotations = []string{"ingress-name", "host", "path", "path-type", "tls-secret", "service", "port", "default-backend", "canary-weight", "ingress-class"}
+
+var indexedAnnotation = regexp.MustCompile(`^([0-9]+)\.(.+)$`)
+
+// annotationError is a problem reading a field of a `Service`'s annotations
+type annotationError struct {
+	field   string
+	message string
+}
+
+func (e annotationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.field, e.message)
+}
+
+// IsAnnotated reports whether the `Service` has a config annotation or any per-field annotations
+func IsAnnotated(service corev1.Service) bool {
+	if service.ObjectMeta.Annotations[ConfigAnnotationKey] != "" {
+		return true
+	}
+	for key := range service.ObjectMeta.Annotations {
+		if _, _, ok := fieldAnnotation(key); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readRoutes reads the routes a `Service`'s annotations configure.
+// The config annotation is read first, then each per-field annotation without an index replaces that field.
+// When there are indexed annotations, each index is a route, in order, with the fields above as its defaults.
+// Templates in hosts and paths are rendered last, with the options' values. strict rejects unknown fields
+func readRoutes(service corev1.Service, strict bool, o Options) (error, []yamlConfig) {
+	base := yamlConfig{}
+	value := service.ObjectMeta.Annotations[ConfigAnnotationKey]
+	if value != "" {
+		err, config := parseConfig(value, strict)
+		if err != nil {
+			return annotationError{field: "config", message: err.Error()}, []yamlConfig{}
+		}
+		base = config
+	}
+
+	indexed := map[int]map[string]string{}
+	for key, value := range service.ObjectMeta.Annotations {
+		index, field, ok := fieldAnnotation(key)
+		if !ok {
+			if strict && isUnknownAnnotation(key) {
+				return annotationError{field: strings.TrimPrefix(key, annotationPrefix), message: "unknown annotation"}, []yamlConfig{}
+			}
+			continue
+		}
+		if index < 0 {
+			err := setField(&base, field, value)
+			if err != nil {
+				return annotationError{field: field, message: err.Error()}, []yamlConfig{}
+			}
+			continue
+		}
+		if indexed[index] == nil {
+			indexed[index] = map[string]string{}
+		}
+		indexed[index][field] = value
+	}
+	if len(indexed) == 0 {
+		err := renderTemplates(service, &base, o.TemplateValues)
+		if err != nil {
+			return err, []yamlConfig{}
+		}
+		return nil, []yamlConfig{base}
+	}
+
+	indexes := []int{}
+	for index := range indexed {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	routes := []yamlConfig{}
+	for _, index := range indexes {
+		route := base
+		route.Index = strconv.Itoa(index)
+		for field, value := range indexed[index] {
+			err := setField(&route, field, value)
+			if err != nil {
+				return annotationError{field: route.Index + "." + field, message: err.Error()}, []yamlConfig{}
+			}
+		}
+		err := renderTemplates(service, &route, o.TemplateValues)
+		if err != nil {
+			templateErr := err.(annotationError)
+			return annotationError{field: route.Index + "." + templateErr.field, message: templateErr.message}, []yamlConfig{}
+		}
+		routes = append(routes, route)
+	}
+
+	return nil, routes
+}
+
+// fieldAnnotation parses a per-field annotation key, with an index of -1 when it has none
+func fieldAnnotation(key string) (int, string, bool) {
+	if !strings.HasPrefix(key, annotationPrefix) {
+		return 0, "", false
+	}
+	name := strings.TrimPrefix(key, annotationPrefix)
+	index := -1
+	if match := indexedAnnotation.FindStringSubmatch(name); match != nil {
+		parsed, err := strconv.Atoi(match[1])
+		if err != nil {
+			return 0, "", false
+		}
+		index, name = parsed, match[2]
+	}
+	for _, field := range fieldAnnotations {
+		if name == field {
+			return index, name, true
+		}
+	}
+
+	return 0, "", false
+}
+
+// isUnknownAnnotation reports whether the key looks like one of our `Service` annotations, but isn't
+func isUnknownAnnotation(key string) bool {
+	if !strings.HasPrefix(key, annotationPrefix) {
+		return false
+	}
+	switch key {
+	case ConfigAnnotationKey, ingressAnnotationKey, pinnedAnnotationKey, OwnerLabelKey:
+		return false
+	}
+
+	return true
+}
+
+func setField(yc *yamlConfig, field, value string) error {
+	switch field {
+	case "ingress-name":
+		yc.Name = value
+	case "host":
+		// a comma separated list
+		yc.Hosts = []string{}
+		for _, host := range strings.Split(value, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				yc.Hosts = append(yc.Hosts, host)
+			}
+		}
+	case "tls-secret":
+		yc.TLSSecret = value
+	case "path":
+		yc.Path = value
+	case "path-type":
+		yc.PathType = value
+	case "service":
+		yc.Service = value
+	case "port":
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		yc.Port = port
+	case "default-backend":
+		defaultBackend, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		yc.DefaultBackend = defaultBackend
+	case "canary-weight":
+		weight, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		yc.Canary = true
+		yc.CanaryWeight = weight
+	case "ingress-class":
+		yc.IngressClass = value
+	}
+
+	return nil
+}
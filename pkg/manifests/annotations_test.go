@@ -0,0 +1,130 @@
+package manifests
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newFlatService(name string, annotations map[string]string) corev1.Service {
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{},
+		},
+	}
+	for key, value := range annotations {
+		service.ObjectMeta.Annotations[annotationPrefix+key] = value
+	}
+
+	return service
+}
+
+func TestReadRoutes(t *testing.T) {
+	flat := newFlatService("web", map[string]string{
+		"ingress-name": "prod",
+		"host":         "web.example.com",
+		"path":         "/web",
+		"service":      "web",
+		"port":         "80",
+	})
+	err, routes := readRoutes(flat, true, Options{})
+	if err != nil {
+		t.Errorf("Error reading routes: %v", err)
+	}
+	expected := []yamlConfig{{Name: "prod", Hosts: []string{"web.example.com"}, Path: "/web", Service: "web", Port: 80}}
+	if !reflect.DeepEqual(expected, routes) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, routes)
+	}
+
+	// per-field annotations replace the config annotation's fields
+	mixed := newFlatService("web", map[string]string{
+		"config": "name: prod\nhost: web.example.com\npath: /web\nservice: web\nport: 80\n",
+		"path":   "/override",
+	})
+	err, routes = readRoutes(mixed, true, Options{})
+	if err != nil {
+		t.Errorf("Error reading routes: %v", err)
+	}
+	expected = []yamlConfig{{Name: "prod", Hosts: []string{"web.example.com"}, Path: "/override", Service: "web", Port: 80, Version: ConfigV1alpha1}}
+	if !reflect.DeepEqual(expected, routes) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, routes)
+	}
+
+	// indexed routes default to the rest
+	indexed := newFlatService("web", map[string]string{
+		"ingress-name": "prod",
+		"service":      "web",
+		"port":         "80",
+		"10.host":      "web.example.net",
+		"2.host":       "web.example.com",
+		"2.port":       "8080",
+	})
+	err, routes = readRoutes(indexed, true, Options{})
+	if err != nil {
+		t.Errorf("Error reading routes: %v", err)
+	}
+	expected = []yamlConfig{
+		{Name: "prod", Hosts: []string{"web.example.com"}, Service: "web", Port: 8080, Index: "2"},
+		{Name: "prod", Hosts: []string{"web.example.net"}, Service: "web", Port: 80, Index: "10"},
+	}
+	if !reflect.DeepEqual(expected, routes) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, routes)
+	}
+
+	unknown := newFlatService("web", map[string]string{"hots": "web.example.com"})
+	if err, _ = readRoutes(unknown, true, Options{}); err == nil {
+		t.Errorf("Expected an error for an unknown annotation")
+	}
+	if err, _ = readRoutes(unknown, false, Options{}); err != nil {
+		t.Errorf("Expected unknown annotations to be ignored, got %v", err)
+	}
+
+	badPort := newFlatService("web", map[string]string{"0.port": "http"})
+	err, _ = readRoutes(badPort, false, Options{})
+	expectedErr := annotationError{field: "0.port", message: "must be a number"}
+	if err != expectedErr {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expectedErr, err)
+	}
+}
+
+func TestFlatAnnotations(t *testing.T) {
+	indexed := newFlatService("web", map[string]string{
+		"service":        "web",
+		"port":           "80",
+		"0.ingress-name": "prod",
+		"0.host":         "web.example.com",
+		"1.ingress-name": "staging",
+		"1.host":         "web.staging.example.com",
+		"1.path":         "api",
+	})
+	if !IsAnnotated(indexed) {
+		t.Errorf("Expected per-field annotations to count")
+	}
+	err, names := IngressNames(indexed)
+	if err != nil || !reflect.DeepEqual([]string{"prod", "staging"}, names) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", []string{"prod", "staging"}, names)
+	}
+
+	problems := ValidateService(indexed, Options{})
+	expected := []Problem{{Namespace: "default", Service: "web", Field: "1.path", Message: "must begin with '/'"}}
+	if !reflect.DeepEqual(expected, problems) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, problems)
+	}
+
+	indexed.ObjectMeta.Annotations[annotationPrefix+"1.path"] = "/api"
+	err, configs := BuildConfigs(corev1.ServiceList{Items: []corev1.Service{indexed}}, nil, Options{})
+	if err != nil {
+		t.Errorf("Error building configs: %v", err)
+	}
+	expectedConfigs := []ingressConfig{
+		{Name: "prod", HostConfigs: []hostConfig{{Host: "web.example.com", PathConfigs: []pathConfig{{Service: "web", Port: 80}}}}},
+		{Name: "staging", HostConfigs: []hostConfig{{Host: "web.staging.example.com", PathConfigs: []pathConfig{{Path: "/api", Service: "web", Port: 80}}}}},
+	}
+	if !reflect.DeepEqual(expectedConfigs, configs) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expectedConfigs, configs)
+	}
+}
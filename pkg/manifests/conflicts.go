@@ -0,0 +1,425 @@
+package manifests
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MaxIngressBytes is the largest `Ingress` we'll apply, etcd's default request size limit
+const MaxIngressBytes = 1572864
+
+// Conflict is a `Service` routing a host and path already routed by an older `Service`,
+// or, when Canary is set, being a canary for a host and path that already has one, or has no route to be a canary for,
+// or, when DefaultBackend is set and there's no host, being the default backend for an `Ingress` an older `Service` already is,
+// or, when SecretName is set, serving a host with a different TLS `Secret` than an older `Service`,
+// or, when ReservedFor is set, naming an `Ingress` with the name of another's shards or canary, which it's reserved for,
+// or, when IngressClass or Annotation is set, setting an `Ingress` class or annotation value other than the oldest `Service` in the `Ingress`
+type Conflict struct {
+	Namespace              string `json:"namespace"`
+	Service                string `json:"service"`
+	Ingress                string `json:"ingress"`
+	Host                   string `json:"host"`
+	Path                   string `json:"path"`
+	DefaultBackend         bool   `json:"defaultBackend,omitempty"`
+	Canary                 bool   `json:"canary,omitempty"`
+	SecretName             string `json:"secretName,omitempty"`
+	ReservedFor            string `json:"reservedFor,omitempty"`
+	IngressClass           string `json:"ingressClass,omitempty"`
+	Annotation             string `json:"annotation,omitempty"`
+	AnnotationValue        string `json:"annotationValue,omitempty"`
+	ClaimedNamespace       string `json:"claimedNamespace"`
+	ClaimedService         string `json:"claimedService"`
+	ClaimedIngress         string `json:"claimedIngress"`
+	ClaimedSecretName      string `json:"claimedSecretName,omitempty"`
+	ClaimedIngressClass    string `json:"claimedIngressClass,omitempty"`
+	ClaimedAnnotationValue string `json:"claimedAnnotationValue,omitempty"`
+}
+
+// what an `Ingress` name can be reserved for
+const (
+	reservedForShards = "shards"
+	reservedForCanary = "canary"
+)
+
+func (c Conflict) Error() string {
+	if c.ReservedFor == reservedForShards {
+		return fmt.Sprintf("%s/%s: Ingress name '%s' is reserved for the shards of Ingress '%s' routed by %s/%s",
+			c.Namespace, c.Service, c.Ingress, c.ClaimedIngress, c.ClaimedNamespace, c.ClaimedService)
+	}
+	if c.ReservedFor == reservedForCanary {
+		return fmt.Sprintf("%s/%s: Ingress name '%s' is reserved for the canary of %s/%s for Ingress '%s'",
+			c.Namespace, c.Service, c.Ingress, c.ClaimedNamespace, c.ClaimedService, c.ClaimedIngress)
+	}
+	if c.Annotation != "" {
+		return fmt.Sprintf("%s/%s: annotation '%s' '%s' for Ingress '%s' differs from %s set by %s/%s",
+			c.Namespace, c.Service, c.Annotation, c.AnnotationValue, c.Ingress, quotedOrNone(c.ClaimedAnnotationValue), c.ClaimedNamespace, c.ClaimedService)
+	}
+	if c.IngressClass != "" {
+		return fmt.Sprintf("%s/%s: class '%s' for Ingress '%s' differs from %s set by %s/%s",
+			c.Namespace, c.Service, c.IngressClass, c.Ingress, quotedOrNone(c.ClaimedIngressClass), c.ClaimedNamespace, c.ClaimedService)
+	}
+	if c.Canary && c.ClaimedService == "" {
+		return fmt.Sprintf("%s/%s: canary for host '%s' path '%s' for Ingress '%s' has no route to split traffic with",
+			c.Namespace, c.Service, c.Host, c.Path, c.Ingress)
+	}
+	if c.Canary {
+		return fmt.Sprintf("%s/%s: host '%s' path '%s' for Ingress '%s' already has canary %s/%s",
+			c.Namespace, c.Service, c.Host, c.Path, c.Ingress, c.ClaimedNamespace, c.ClaimedService)
+	}
+	if c.DefaultBackend && c.Host == "" {
+		return fmt.Sprintf("%s/%s: default backend for Ingress '%s' is already %s/%s",
+			c.Namespace, c.Service, c.Ingress, c.ClaimedNamespace, c.ClaimedService)
+	}
+	if c.DefaultBackend {
+		return fmt.Sprintf("%s/%s: default backend for host '%s' for Ingress '%s' is already routed by %s/%s for Ingress '%s'",
+			c.Namespace, c.Service, c.Host, c.Ingress, c.ClaimedNamespace, c.ClaimedService, c.ClaimedIngress)
+	}
+	if c.SecretName != "" {
+		return fmt.Sprintf("%s/%s: host '%s' for Ingress '%s' is already served with TLS Secret '%s' rather than '%s' by %s/%s for Ingress '%s'",
+			c.Namespace, c.Service, c.Host, c.Ingress, c.ClaimedSecretName, c.SecretName, c.ClaimedNamespace, c.ClaimedService, c.ClaimedIngress)
+	}
+	return fmt.Sprintf("%s/%s: host '%s' path '%s' for Ingress '%s' is already routed by %s/%s for Ingress '%s'",
+		c.Namespace, c.Service, c.Host, c.Path, c.Ingress, c.ClaimedNamespace, c.ClaimedService, c.ClaimedIngress)
+}
+
+// quotedOrNone quotes a setting's value, or says there's none
+func quotedOrNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+
+	return "'" + value + "'"
+}
+
+// FindConflicts finds the `Service`s routing a host and path that an older `Service` already routes,
+// or serving a host with a different TLS `Secret`, in any `Ingress`, or being the default backend for an `Ingress` that already has one,
+// or being a canary for a route that already has one, or that doesn't exist in the same `Ingress`,
+// older being by creation time then namespace and name, or using an `Ingress` name reserved for another's shards or canary, whatever its age.
+// The oldest `Service` in each `Ingress` decides its class and annotations, which the others may repeat or leave out, but not change.
+// With o.MaxRulesPerIngress set, `<ingress>-<N>` is reserved for the shards of each `Ingress` configured, sharded yet or not, so names don't change hands as hosts are added.
+// Routes are compared by the paths they're translated to for the target controller, so differently written paths that match the same requests conflict.
+// The hosts and routes with host policy violations take no part.
+// expects all services passed to be annotated and valid
+func FindConflicts(sl corev1.ServiceList, violations []Violation, o Options) []Conflict {
+	services := sortByAge(sl.Items)
+
+	type claim struct {
+		service     corev1.Service
+		ingress     string
+		secret      string
+		class       string
+		annotations map[string]string
+	}
+	// the first claim on any of the routes
+	claimedRoute := func(claims map[string]claim, routes []string) (claim, bool) {
+		for _, route := range routes {
+			if claimed, ok := claims[route]; ok {
+				return claimed, true
+			}
+		}
+		return claim{}, false
+	}
+	claims := map[string]claim{}
+	secretClaims := map[string]claim{}
+	defaultClaims := map[string]claim{}
+	canaryClaims := map[string]claim{}
+	// the class and annotations of each `Ingress`, by name
+	settingsClaims := map[string]claim{}
+	type canaryRoute struct {
+		service corev1.Service
+		route   yamlConfig
+	}
+	canaries := []canaryRoute{}
+	conflicts := []Conflict{}
+	for _, service := range services {
+		err, routes := readRoutes(service, false, o)
+		if err != nil {
+			continue
+		}
+		for _, yc := range allowedRoutes(service, routes, violations) {
+			claimed, ok := settingsClaims[yc.ingressName()]
+			// a `Service`'s own routes add to its claim, the first value of each setting winning like in BuildConfigs
+			own := ok && claimed.service.ObjectMeta.Namespace == service.ObjectMeta.Namespace && claimed.service.ObjectMeta.Name == service.ObjectMeta.Name
+			if !ok || own {
+				if !ok {
+					claimed = claim{service: service, ingress: yc.ingressName(), annotations: map[string]string{}}
+				}
+				if claimed.class == "" {
+					claimed.class = yc.IngressClass
+				}
+				for key, value := range yc.IngressAnnotations {
+					if _, set := claimed.annotations[key]; !set {
+						claimed.annotations[key] = value
+					}
+				}
+				settingsClaims[yc.ingressName()] = claimed
+			} else {
+				if yc.IngressClass != "" && yc.IngressClass != claimed.class {
+					conflicts = append(conflicts, Conflict{
+						Namespace:           service.ObjectMeta.Namespace,
+						Service:             service.ObjectMeta.Name,
+						Ingress:             yc.ingressName(),
+						IngressClass:        yc.IngressClass,
+						ClaimedNamespace:    claimed.service.ObjectMeta.Namespace,
+						ClaimedService:      claimed.service.ObjectMeta.Name,
+						ClaimedIngress:      claimed.ingress,
+						ClaimedIngressClass: claimed.class,
+					})
+				}
+				for _, key := range sortedKeys(yc.IngressAnnotations) {
+					if yc.IngressAnnotations[key] == claimed.annotations[key] {
+						continue
+					}
+					conflicts = append(conflicts, Conflict{
+						Namespace:              service.ObjectMeta.Namespace,
+						Service:                service.ObjectMeta.Name,
+						Ingress:                yc.ingressName(),
+						Annotation:             key,
+						AnnotationValue:        yc.IngressAnnotations[key],
+						ClaimedNamespace:       claimed.service.ObjectMeta.Namespace,
+						ClaimedService:         claimed.service.ObjectMeta.Name,
+						ClaimedIngress:         claimed.ingress,
+						ClaimedAnnotationValue: claimed.annotations[key],
+					})
+				}
+			}
+
+			// checked once every route they could be a canary for has been claimed
+			if yc.Canary {
+				canaries = append(canaries, canaryRoute{service: service, route: yc})
+				continue
+			}
+			if yc.DefaultBackend && len(yc.Hosts) == 0 {
+				claimed, ok := defaultClaims[yc.Name]
+				if !ok {
+					defaultClaims[yc.Name] = claim{service: service, ingress: yc.Name}
+				} else {
+					conflicts = append(conflicts, Conflict{
+						Namespace:        service.ObjectMeta.Namespace,
+						Service:          service.ObjectMeta.Name,
+						Ingress:          yc.Name,
+						DefaultBackend:   true,
+						ClaimedNamespace: claimed.service.ObjectMeta.Namespace,
+						ClaimedService:   claimed.service.ObjectMeta.Name,
+						ClaimedIngress:   claimed.ingress,
+					})
+				}
+				continue
+			}
+			for _, host := range yc.routeHosts() {
+				routes := translatedRoutes(o, host, yc)
+				claimed, ok := claimedRoute(claims, routes)
+				if !ok {
+					for _, route := range routes {
+						claims[route] = claim{service: service, ingress: yc.Name}
+					}
+				} else {
+					conflicts = append(conflicts, Conflict{
+						Namespace:        service.ObjectMeta.Namespace,
+						Service:          service.ObjectMeta.Name,
+						Ingress:          yc.Name,
+						Host:             host,
+						Path:             yc.Path,
+						DefaultBackend:   yc.DefaultBackend,
+						ClaimedNamespace: claimed.service.ObjectMeta.Namespace,
+						ClaimedService:   claimed.service.ObjectMeta.Name,
+						ClaimedIngress:   claimed.ingress,
+					})
+				}
+
+				if yc.TLSSecret == "" || host == "" {
+					continue
+				}
+				claimed, ok = secretClaims[host]
+				if !ok {
+					secretClaims[host] = claim{service: service, ingress: yc.Name, secret: yc.TLSSecret}
+				} else if claimed.secret != yc.TLSSecret {
+					conflicts = append(conflicts, Conflict{
+						Namespace:         service.ObjectMeta.Namespace,
+						Service:           service.ObjectMeta.Name,
+						Ingress:           yc.Name,
+						Host:              host,
+						SecretName:        yc.TLSSecret,
+						ClaimedNamespace:  claimed.service.ObjectMeta.Namespace,
+						ClaimedService:    claimed.service.ObjectMeta.Name,
+						ClaimedIngress:    claimed.ingress,
+						ClaimedSecretName: claimed.secret,
+					})
+				}
+			}
+		}
+	}
+
+	for _, canary := range canaries {
+		service, yc := canary.service, canary.route
+		for _, host := range yc.routeHosts() {
+			routes := translatedRoutes(o, host, yc)
+			conflict := Conflict{
+				Namespace: service.ObjectMeta.Namespace,
+				Service:   service.ObjectMeta.Name,
+				Ingress:   yc.ingressName(),
+				Host:      host,
+				Path:      yc.Path,
+				Canary:    true,
+			}
+			// nginx only splits traffic for a route in another `Ingress`
+			split := true
+			for _, route := range routes {
+				if claimed, ok := claims[route]; !ok || claimed.ingress != yc.Name {
+					split = false
+				}
+			}
+			if !split {
+				conflicts = append(conflicts, conflict)
+				continue
+			}
+			claimed, ok := claimedRoute(canaryClaims, routes)
+			if !ok {
+				for _, route := range routes {
+					canaryClaims[route] = claim{service: service, ingress: yc.ingressName()}
+				}
+				continue
+			}
+			conflict.ClaimedNamespace = claimed.service.ObjectMeta.Namespace
+			conflict.ClaimedService = claimed.service.ObjectMeta.Name
+			conflict.ClaimedIngress = claimed.ingress
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	return append(conflicts, findReservedNames(services, violations, o)...)
+}
+
+// findReservedNames finds the routes using an `Ingress` name the controller builds another `Ingress` with,
+// a shard of one with o.MaxRulesPerIngress set, or a canary's. Expects the services ordered oldest first
+func findReservedNames(services []corev1.Service, violations []Violation, o Options) []Conflict {
+	type claim struct {
+		service corev1.Service
+		ingress string
+	}
+	// the oldest `Service` routing each `Ingress`, and each canary's
+	ingresses := map[string]claim{}
+	canaries := map[string]claim{}
+	for _, service := range services {
+		err, routes := readRoutes(service, false, o)
+		if err != nil {
+			continue
+		}
+		for _, yc := range allowedRoutes(service, routes, violations) {
+			claims := ingresses
+			if yc.Canary {
+				claims = canaries
+			}
+			if _, ok := claims[yc.ingressName()]; !ok {
+				claims[yc.ingressName()] = claim{service: service, ingress: yc.Name}
+			}
+		}
+	}
+
+	conflicts := []Conflict{}
+	for _, service := range services {
+		err, routes := readRoutes(service, false, o)
+		if err != nil {
+			continue
+		}
+		reported := map[string]bool{}
+		for _, yc := range allowedRoutes(service, routes, violations) {
+			if yc.Canary || reported[yc.Name] {
+				continue
+			}
+			conflict := Conflict{
+				Namespace: service.ObjectMeta.Namespace,
+				Service:   service.ObjectMeta.Name,
+				Ingress:   yc.Name,
+			}
+			claimed, ok := canaries[yc.Name]
+			if ok {
+				conflict.ReservedFor = reservedForCanary
+			} else if base, isShard := shardBase(yc.Name); isShard && o.MaxRulesPerIngress > 0 {
+				claimed, ok = ingresses[base]
+				conflict.ReservedFor = reservedForShards
+			}
+			if !ok {
+				continue
+			}
+			reported[yc.Name] = true
+			conflict.ClaimedNamespace = claimed.service.ObjectMeta.Namespace
+			conflict.ClaimedService = claimed.service.ObjectMeta.Name
+			conflict.ClaimedIngress = claimed.ingress
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	return conflicts
+}
+
+// translatedRoutes are the host and path pairs a route's path is translated to for the target controller
+func translatedRoutes(o Options, host string, yc yamlConfig) []string {
+	paths, _ := translatePath(o.controller(), yc.PathType, yc.Path)
+	routes := []string{}
+	for _, path := range paths {
+		routes = append(routes, host+path)
+	}
+
+	return routes
+}
+
+// sortByAge orders `Service`s oldest first, by creation time then namespace and name
+func sortByAge(items []corev1.Service) []corev1.Service {
+	services := append([]corev1.Service{}, items...)
+	sort.SliceStable(services, func(i, j int) bool {
+		a, b := services[i].ObjectMeta, services[j].ObjectMeta
+		// ones without a creation time, such as from files, haven't been created yet
+		if a.CreationTimestamp.IsZero() != b.CreationTimestamp.IsZero() {
+			return b.CreationTimestamp.IsZero()
+		}
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	return services
+}
+
+// ExcludeConflicts drops the `Service`s that lost the conflicts
+func ExcludeConflicts(sl corev1.ServiceList, conflicts []Conflict) corev1.ServiceList {
+	serviceList := corev1.ServiceList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+	}
+	lost := map[string]bool{}
+	for _, conflict := range conflicts {
+		lost[conflict.Namespace+"/"+conflict.Service] = true
+	}
+	for _, service := range sl.Items {
+		if !lost[service.ObjectMeta.Namespace+"/"+service.ObjectMeta.Name] {
+			serviceList.Items = append(serviceList.Items, service)
+		}
+	}
+
+	return serviceList
+}
+
+// CheckLimits returns an error when the `Ingress` is too big to apply
+func CheckLimits(ingress v1beta1.Ingress) error {
+	out, err := json.Marshal(ingress)
+	if err != nil {
+		return err
+	}
+	if len(out) > MaxIngressBytes {
+		return fmt.Errorf("Ingress '%s' is %d bytes, more than the limit of %d", ingress.ObjectMeta.Name, len(out), MaxIngressBytes)
+	}
+
+	return nil
+}
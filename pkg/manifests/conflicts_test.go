@@ -0,0 +1,233 @@
+package manifests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFindConflicts(t *testing.T) {
+	old := newAnnotatedService("old", "name: prod\nhost: web.example.com\npath: /\nservice: old\nport: 80\n")
+	old.CreationTimestamp = metav1.NewTime(time.Unix(200, 0))
+	older := newAnnotatedService("older", "name: staging\nhost: web.example.com\npath: /\nservice: older\nport: 80\n")
+	older.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+	// not created yet
+	local := newAnnotatedService("a-local", "name: prod\nhost: web.example.com\npath: /\nservice: local\nport: 80\n")
+	other := newAnnotatedService("other", "name: prod\nhost: web.example.com\npath: /other\nservice: other\nport: 80\n")
+	sl := corev1.ServiceList{Items: []corev1.Service{old, older, local, other}}
+
+	result := FindConflicts(sl, nil, Options{})
+	expected := []Conflict{
+		{
+			Namespace: "default", Service: "old", Ingress: "prod", Host: "web.example.com", Path: "/",
+			ClaimedNamespace: "default", ClaimedService: "older", ClaimedIngress: "staging",
+		},
+		{
+			Namespace: "default", Service: "a-local", Ingress: "prod", Host: "web.example.com", Path: "/",
+			ClaimedNamespace: "default", ClaimedService: "older", ClaimedIngress: "staging",
+		},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	names := []string{}
+	for _, service := range ExcludeConflicts(sl, result).Items {
+		names = append(names, service.Name)
+	}
+	if !reflect.DeepEqual([]string{"older", "other"}, names) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", []string{"older", "other"}, names)
+	}
+}
+
+func TestFindConflictsTLS(t *testing.T) {
+	web := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\nhosts: [web.example.com, \"*.example.com\"]\ntls:\n  secretName: example-tls\nbackend:\n  service: web\n  port: 80\n")
+	same := newAnnotatedService("x-same", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /same\ntls:\n  secretName: example-tls\nbackend:\n  service: same\n  port: 80\n")
+	other := newAnnotatedService("x-other", "apiVersion: v1beta1\ningress: prod\nhost: api.example.com\npath: /other\ntls:\n  secretName: other-tls\nbackend:\n  service: other\n  port: 80\n")
+	wildcard := newAnnotatedService("x-wildcard", "apiVersion: v1beta1\ningress: prod\nhost: \"*.example.com\"\npath: /other\ntls:\n  secretName: other-tls\nbackend:\n  service: other\n  port: 80\n")
+	sl := corev1.ServiceList{Items: []corev1.Service{web, same, other, wildcard}}
+
+	result := FindConflicts(sl, nil, Options{})
+	expected := []Conflict{
+		{
+			Namespace: "default", Service: "x-wildcard", Ingress: "prod", Host: "*.example.com", SecretName: "other-tls",
+			ClaimedNamespace: "default", ClaimedService: "web", ClaimedIngress: "prod", ClaimedSecretName: "example-tls",
+		},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestFindConflictsDefaultBackend(t *testing.T) {
+	fallback := newAnnotatedService("fallback", "apiVersion: v1beta1\ningress: prod\ndefaultBackend: true\nbackend:\n  service: fallback\n  port: 80\n")
+	other := newAnnotatedService("other", "apiVersion: v1beta1\ningress: prod\ndefaultBackend: true\nbackend:\n  service: other\n  port: 80\n")
+	staging := newAnnotatedService("staging", "apiVersion: v1beta1\ningress: staging\ndefaultBackend: true\nbackend:\n  service: staging\n  port: 80\n")
+	web := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\nbackend:\n  service: web\n  port: 80\n")
+	catchAll := newAnnotatedService("x-catch-all", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\ndefaultBackend: true\nbackend:\n  service: catch-all\n  port: 80\n")
+	sl := corev1.ServiceList{Items: []corev1.Service{fallback, other, staging, web, catchAll}}
+
+	result := FindConflicts(sl, nil, Options{})
+	expected := []Conflict{
+		{
+			Namespace: "default", Service: "other", Ingress: "prod", DefaultBackend: true,
+			ClaimedNamespace: "default", ClaimedService: "fallback", ClaimedIngress: "prod",
+		},
+		{
+			Namespace: "default", Service: "x-catch-all", Ingress: "prod", Host: "web.example.com", DefaultBackend: true,
+			ClaimedNamespace: "default", ClaimedService: "web", ClaimedIngress: "prod",
+		},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestFindConflictsCanary(t *testing.T) {
+	web := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /\nbackend:\n  service: web\n  port: 80\n")
+	// canaries are checked after every other route, so being older doesn't matter
+	canary := newAnnotatedService("a-canary", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /\ncanary:\n  weight: 20\nbackend:\n  service: next\n  port: 80\n")
+	second := newAnnotatedService("b-canary", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /\ncanary:\n  weight: 50\nbackend:\n  service: other\n  port: 80\n")
+	stray := newAnnotatedService("c-canary", "apiVersion: v1beta1\ningress: staging\nhost: web.example.com\npath: /\ncanary:\n  weight: 50\nbackend:\n  service: stray\n  port: 80\n")
+	sl := corev1.ServiceList{Items: []corev1.Service{web, canary, second, stray}}
+
+	result := FindConflicts(sl, nil, Options{})
+	expected := []Conflict{
+		{
+			Namespace: "default", Service: "b-canary", Ingress: "prod-canary-other", Host: "web.example.com", Path: "/", Canary: true,
+			ClaimedNamespace: "default", ClaimedService: "a-canary", ClaimedIngress: "prod-canary-next",
+		},
+		{
+			Namespace: "default", Service: "c-canary", Ingress: "staging-canary-stray", Host: "web.example.com", Path: "/", Canary: true,
+		},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestFindConflictsTranslatedPaths(t *testing.T) {
+	// both are the prefix `/api` for nginx
+	glob := newAnnotatedService("a-glob", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /api/*\nbackend:\n  service: glob\n  port: 80\n")
+	prefix := newAnnotatedService("b-prefix", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /api\nbackend:\n  service: prefix\n  port: 80\n")
+	sl := corev1.ServiceList{Items: []corev1.Service{glob, prefix}}
+	expected := []Conflict{
+		{
+			Namespace: "default", Service: "b-prefix", Ingress: "prod", Host: "web.example.com", Path: "/api",
+			ClaimedNamespace: "default", ClaimedService: "a-glob", ClaimedIngress: "prod",
+		},
+	}
+	if result := FindConflicts(sl, nil, Options{TargetController: ControllerNginx}); !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+	// but different paths for GCE
+	if result := FindConflicts(sl, nil, Options{}); len(result) != 0 {
+		t.Errorf("Expected no conflicts for GCE, got %v", result)
+	}
+
+	// a Prefix is both `/api` and `/api/*` for GCE
+	typed := newAnnotatedService("a-typed", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /api\npathType: Prefix\nbackend:\n  service: typed\n  port: 80\n")
+	sl = corev1.ServiceList{Items: []corev1.Service{typed, glob}}
+	expected = []Conflict{
+		{
+			Namespace: "default", Service: "a-typed", Ingress: "prod", Host: "web.example.com", Path: "/api",
+			ClaimedNamespace: "default", ClaimedService: "a-glob", ClaimedIngress: "prod",
+		},
+	}
+	if result := FindConflicts(sl, nil, Options{}); !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestFindConflictsReservedNames(t *testing.T) {
+	prod := newAnnotatedService("prod", "apiVersion: v1beta1\ningress: prod\nhosts: [a.example.com, b.example.com]\nbackend:\n  service: prod\n  port: 80\n")
+	// older, but the name is still the shard's
+	shard := newAnnotatedService("shard", "apiVersion: v1beta1\ningress: prod-1\nhost: c.example.com\nbackend:\n  service: shard\n  port: 80\n")
+	shard.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+	canary := newAnnotatedService("canary", "apiVersion: v1beta1\ningress: prod\nhost: a.example.com\ncanary:\n  weight: 20\nbackend:\n  service: next\n  port: 80\n")
+	named := newAnnotatedService("named", "apiVersion: v1beta1\ningress: prod-canary-next\nhost: d.example.com\nbackend:\n  service: named\n  port: 80\n")
+	sl := corev1.ServiceList{Items: []corev1.Service{prod, shard, canary, named}}
+
+	shardConflict := Conflict{
+		Namespace: "default", Service: "shard", Ingress: "prod-1", ReservedFor: "shards",
+		ClaimedNamespace: "default", ClaimedService: "prod", ClaimedIngress: "prod",
+	}
+	canaryConflict := Conflict{
+		Namespace: "default", Service: "named", Ingress: "prod-canary-next", ReservedFor: "canary",
+		ClaimedNamespace: "default", ClaimedService: "canary", ClaimedIngress: "prod",
+	}
+	expected := []Conflict{shardConflict, canaryConflict}
+	if result := FindConflicts(sl, nil, Options{MaxRulesPerIngress: 1}); !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+	// shard names are only reserved when sharding
+	expected = []Conflict{canaryConflict}
+	if result := FindConflicts(sl, nil, Options{}); !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+	if !strings.Contains(shardConflict.Error(), "reserved for the shards of Ingress 'prod'") {
+		t.Errorf("Expected the Ingress it's reserved for in %q", shardConflict.Error())
+	}
+}
+
+func TestFindConflictsIngressSettings(t *testing.T) {
+	settings := func(name, host, extra string, created int64) corev1.Service {
+		service := newAnnotatedService(name, "apiVersion: v1beta1\ningress: prod\nhost: "+host+".example.com\n"+extra+"backend:\n  service: "+name+"\n  port: 80\n")
+		service.CreationTimestamp = metav1.NewTime(time.Unix(created, 0))
+		return service
+	}
+	web := settings("web", "web", "ingressClass: public\ningressAnnotations:\n  example.com/a: '1'\n", 100)
+	// first by name, but newer, so it can't move the Ingress to another class
+	hijack := settings("aaa", "aaa", "ingressClass: internal\n", 200)
+	same := settings("same", "same", "ingressClass: public\ningressAnnotations:\n  example.com/a: '1'\n", 300)
+	unset := settings("unset", "unset", "", 400)
+	other := settings("other", "other", "ingressAnnotations:\n  example.com/a: '2'\n  example.com/b: x\n", 500)
+	sl := corev1.ServiceList{Items: []corev1.Service{web, hijack, same, unset, other}}
+
+	result := FindConflicts(sl, nil, Options{})
+	expected := []Conflict{
+		{
+			Namespace: "default", Service: "aaa", Ingress: "prod", IngressClass: "internal",
+			ClaimedNamespace: "default", ClaimedService: "web", ClaimedIngress: "prod", ClaimedIngressClass: "public",
+		},
+		{
+			Namespace: "default", Service: "other", Ingress: "prod", Annotation: "example.com/a", AnnotationValue: "2",
+			ClaimedNamespace: "default", ClaimedService: "web", ClaimedIngress: "prod", ClaimedAnnotationValue: "1",
+		},
+		{
+			Namespace: "default", Service: "other", Ingress: "prod", Annotation: "example.com/b", AnnotationValue: "x",
+			ClaimedNamespace: "default", ClaimedService: "web", ClaimedIngress: "prod",
+		},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+	if message := expected[2].Error(); !strings.Contains(message, "annotation 'example.com/b' 'x' for Ingress 'prod' differs from none set by default/web") {
+		t.Errorf("Unexpected message %q", message)
+	}
+
+	// the oldest having none doesn't let a newer one set it
+	old := settings("old", "old", "", 50)
+	sl = corev1.ServiceList{Items: []corev1.Service{old, hijack}}
+	result = FindConflicts(sl, nil, Options{})
+	if len(result) != 1 || result[0].Service != "aaa" || result[0].ClaimedService != "old" {
+		t.Errorf("Expected 'aaa' to conflict with 'old', got %v", result)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	ingress := newIngress("prod", []v1beta1.IngressRule{{Host: "web.example.com"}})
+	if err := CheckLimits(ingress); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	ingress.ObjectMeta.Annotations["big"] = strings.Repeat("x", MaxIngressBytes)
+	if err := CheckLimits(ingress); err == nil {
+		t.Errorf("Expected an error for an Ingress over the limit")
+	}
+}
@@ -0,0 +1,151 @@
+package manifests
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// ToYAML renders a Kubernetes object the way `kubectl get -o yaml` would
+func ToYAML(obj interface{}) (error, string) {
+	out, err := yaml.Marshal(obj)
+	if err != nil {
+		return err, ""
+	}
+
+	return nil, string(out)
+}
+
+// UnifiedDiff returns a unified diff from a to b, or an empty string when they're the same
+func UnifiedDiff(aName, bName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	aLines := splitLines(a)
+	bLines := splitLines(b)
+	edits := diffLines(aLines, bLines)
+
+	const context = 3
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", aName, bName)
+	for start := 0; start < len(edits); {
+		// find the next change
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+		// grow the hunk until there's more than twice the context between changes
+		hunkStart := start - context
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		end := start
+		for i := start; i < len(edits); i++ {
+			if edits[i].op != ' ' {
+				end = i
+			} else if i-end > 2*context {
+				break
+			}
+		}
+		hunkEnd := end + context + 1
+		if hunkEnd > len(edits) {
+			hunkEnd = len(edits)
+		}
+
+		aStart, bStart, aCount, bCount := 0, 0, 0, 0
+		for _, edit := range edits[:hunkStart] {
+			if edit.op != '+' {
+				aStart++
+			}
+			if edit.op != '-' {
+				bStart++
+			}
+		}
+		for _, edit := range edits[hunkStart:hunkEnd] {
+			if edit.op != '+' {
+				aCount++
+			}
+			if edit.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, edit := range edits[hunkStart:hunkEnd] {
+			fmt.Fprintf(out, "%c%s\n", edit.op, edit.line)
+		}
+		start = hunkEnd
+	}
+
+	return out.String()
+}
+
+type lineEdit struct {
+	op   byte
+	line string
+}
+
+// diffLines finds the shortest edit from a to b via their longest common subsequence
+func diffLines(a, b []string) []lineEdit {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	edits := []lineEdit{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, lineEdit{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, lineEdit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, lineEdit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, lineEdit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, lineEdit{'+', b[j]})
+	}
+
+	return edits
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,77 @@
+package manifests
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	b := "one\ntwo\nthree\nfour\nfive\nsix\nseven\nEIGHT\nnine\nten\neleven\n"
+	result := UnifiedDiff("a", "b", a, b)
+	expected := `--- a
++++ b
+@@ -5,6 +5,7 @@
+ five
+ six
+ seven
+-eight
++EIGHT
+ nine
+ ten
++eleven
+`
+	if result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// separate hunks
+	a = "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	b = "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n"
+	result = UnifiedDiff("a", "b", a, b)
+	expected = `--- a
++++ b
+@@ -1,3 +1,4 @@
++0
+ 1
+ 2
+ 3
+@@ -9,4 +10,3 @@
+ 9
+ 10
+ 11
+-12
+`
+	if result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// new file
+	result = UnifiedDiff("/dev/null", "b", "", "x\ny\n")
+	expected = `--- /dev/null
++++ b
+@@ -0,0 +1,2 @@
++x
++y
+`
+	if result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// no change
+	if UnifiedDiff("a", "b", a, a) != "" {
+		t.Errorf("Expected no diff for identical input")
+	}
+}
+
+func TestToYAML(t *testing.T) {
+	err, result := ToYAML(aIngress())
+	if err != nil {
+		t.Errorf("Error rendering YAML: %v", err)
+	}
+	for _, expected := range []string{"kind: Ingress", "name: a-ingress", "- host: a-ingress.example.com", "serviceName: web"} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("Expected YAML to contain %q, got:\n%v", expected, result)
+		}
+	}
+}
@@ -1,30 +1,76 @@
 package manifests
 
 import (
-	"gopkg.in/yaml.v2"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
-	"github.com/operator-framework/operator-sdk/pkg/sdk"
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	extensionslisters "k8s.io/client-go/listers/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
 )
 
-const configAnnotationKey = "ingress-controller-controller.alpha.davidamick.com/config"
+// ConfigAnnotationKey is the annotation on `Service`s holding their config
+const ConfigAnnotationKey = "ingress-controller-controller.alpha.davidamick.com/config"
 const ingressAnnotationKey = "ingress-controller-controller.alpha.davidamick.com/managed"
+const pinnedAnnotationKey = "ingress-controller-controller.alpha.davidamick.com/pinned"
+
+// ingressClassAnnotationKey is the annotation ingress controllers pick their `Ingress`s by
+const ingressClassAnnotationKey = "kubernetes.io/ingress.class"
+
+// configuredAnnotationsKey lists the `Ingress` annotations set from config annotations, so that they're removed along with them
+const configuredAnnotationsKey = "ingress-controller-controller.alpha.davidamick.com/configured-annotations"
+
+// IngressNameIndex is the name of the `Service` informer index built by IngressNameIndexFunc
+const IngressNameIndex = "ingressName"
 
 type yamlConfig struct {
-	Name    string `yaml:"name"`
-	Host    string `yaml:"host"`
-	Path    string `yaml:"path"`
-	Service string `yaml:"service"`
-	Port    int    `yaml:"port"`
+	Name  string   `yaml:"name"`
+	Hosts []string `yaml:"hosts"`
+	Path  string   `yaml:"path"`
+	// PathType is how Path is matched, one of the PathType constants, or as written for the controller when unset
+	PathType string `yaml:"pathType"`
+	// TLSSecret is the `Secret` with the certificate for the hosts, if they're served over TLS
+	TLSSecret string `yaml:"tlsSecret"`
+	Service   string `yaml:"service"`
+	Port      int    `yaml:"port"`
+	// DefaultBackend routes what nothing else matches, for the `Ingress` when there are no hosts, otherwise for each host
+	DefaultBackend bool `yaml:"defaultBackend"`
+	// Canary splits CanaryWeight percent of the traffic for the same hosts and path in the `Ingress` to this backend
+	Canary       bool `yaml:"canary"`
+	CanaryWeight int  `yaml:"canaryWeight"`
+	// IngressClass is the class of the `Ingress`, for its `kubernetes.io/ingress.class` annotation
+	IngressClass string `yaml:"ingressClass"`
+	// IngressAnnotations are set on the `Ingress`, such as settings of the ingress controller
+	IngressAnnotations map[string]string `yaml:"ingressAnnotations"`
+	// Index is the route's index in indexed per-field annotations, if it came from them
+	Index string `yaml:"-"`
+	// Version is the version of the config annotation it was read from, if any
+	Version string `yaml:"-"`
 }
 
 type ingressConfig struct {
 	Name        string
 	HostConfigs []hostConfig
+	TLSConfigs  []tlsConfig
+	// Backend is the default backend, if any
+	Backend *pathConfig
+	// Canary is the canary weight, if the `Ingress` is for a canary
+	Canary *int
+	// Class is the `Ingress`'s class, if any
+	Class string
+	// Annotations are the `Ingress` annotations the routes set
+	Annotations map[string]string
 }
 
 type hostConfig struct {
@@ -33,28 +79,77 @@ type hostConfig struct {
 }
 
 type pathConfig struct {
-	Path    string
-	Service string
-	Port    int
+	Path     string
+	PathType string
+	Service  string
+	Port     int
+}
+
+type tlsConfig struct {
+	SecretName string
+	Hosts      []string
 }
 
-// List all `Service` objects
-func GetAllServices() (error, corev1.ServiceList) {
+// ingressName is the `Ingress` the route is built into, each canary backend getting its own, as nginx requires
+func (yc yamlConfig) ingressName() string {
+	if !yc.Canary {
+		return yc.Name
+	}
+
+	return yc.Name + "-canary-" + yc.Service
+}
+
+// routeHosts are the hosts a route is for, which is one empty host, matching any, when none are set
+func (yc yamlConfig) routeHosts() []string {
+	if len(yc.Hosts) == 0 {
+		return []string{""}
+	}
+
+	return yc.Hosts
+}
+
+// List all `Service` objects from the cache
+func GetAllServices(lister corelisters.ServiceNamespaceLister) (error, corev1.ServiceList) {
 	serviceList := corev1.ServiceList{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Service",
 			APIVersion: "v1",
 		},
 	}
-	err := sdk.List("default", &serviceList) // TODO set the namespace via config
+	services, err := lister.List(labels.Everything())
 	if err != nil {
 		logrus.Errorf("Failed to query Services : %v", err)
 		return err, corev1.ServiceList{}
 	}
+	// listers return items in no particular order, and BuildConfigs keeps the order it's given
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	for _, service := range services {
+		serviceList.Items = append(serviceList.Items, cachedService(service))
+	}
 
 	return nil, serviceList
 }
 
+// FromCache copies a `Service`, `Ingress` or `ConfigMap` out of a cache, with the TypeMeta informers don't keep,
+// which the sdk needs to find the resource
+func FromCache(object runtime.Object) runtime.Object {
+	copied := object.DeepCopyObject()
+	switch copied.(type) {
+	case *corev1.Service:
+		copied.GetObjectKind().SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Service"))
+	case *corev1.ConfigMap:
+		copied.GetObjectKind().SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+	case *v1beta1.Ingress:
+		copied.GetObjectKind().SetGroupVersionKind(v1beta1.SchemeGroupVersion.WithKind("Ingress"))
+	}
+
+	return copied
+}
+
+func cachedService(service *corev1.Service) corev1.Service {
+	return *FromCache(service).(*corev1.Service)
+}
+
 // Find the `Service`s that have the right annotation
 func GetAnnotatedServices(sl corev1.ServiceList) corev1.ServiceList {
 	serviceList := corev1.ServiceList{
@@ -64,7 +159,82 @@ func GetAnnotatedServices(sl corev1.ServiceList) corev1.ServiceList {
 		},
 	}
 	for _, service := range sl.Items {
-		if service.ObjectMeta.Annotations[configAnnotationKey] != "" { // TODO how to set?
+		if IsAnnotated(service) {
+			serviceList.Items = append(serviceList.Items, service)
+		}
+	}
+
+	return serviceList
+}
+
+// IngressNames lists the names of the `Ingress`s a `Service`'s annotation contributes to
+func IngressNames(service corev1.Service) (error, []string) {
+	if !IsAnnotated(service) {
+		return nil, []string{}
+	}
+	// names aren't templated, so the options don't change them
+	err, routes := readRoutes(service, false, Options{})
+	if err != nil {
+		return err, []string{}
+	}
+	names := []string{}
+	found := map[string]bool{}
+	for _, route := range routes {
+		if !found[route.ingressName()] {
+			found[route.ingressName()] = true
+			names = append(names, route.ingressName())
+		}
+	}
+
+	return nil, names
+}
+
+// IngressNameIndexFunc indexes `Service`s by the `Ingress`s they contribute to.
+// `Service`s with unreadable annotations aren't indexed
+func IngressNameIndexFunc(obj interface{}) ([]string, error) {
+	service, ok := obj.(*corev1.Service)
+	if !ok {
+		return []string{}, nil
+	}
+	_, names := IngressNames(*service)
+
+	return names, nil
+}
+
+// List the `Service`s contributing to the named `Ingress` from an indexer with IngressNameIndex
+func GetServicesForIngress(indexer cache.Indexer, name string) (error, corev1.ServiceList) {
+	serviceList := corev1.ServiceList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+	}
+	objects, err := indexer.ByIndex(IngressNameIndex, name)
+	if err != nil {
+		logrus.Errorf("Failed to query Services for Ingress '%s' : %v", name, err)
+		return err, corev1.ServiceList{}
+	}
+	for _, object := range objects {
+		service, ok := object.(*corev1.Service)
+		if ok {
+			serviceList.Items = append(serviceList.Items, cachedService(service))
+		}
+	}
+	sort.Slice(serviceList.Items, func(i, j int) bool { return serviceList.Items[i].Name < serviceList.Items[j].Name })
+
+	return nil, serviceList
+}
+
+// ExcludeServices drops the `Service`s with the given UIDs, such as ones we know were deleted
+func ExcludeServices(sl corev1.ServiceList, uids map[types.UID]bool) corev1.ServiceList {
+	serviceList := corev1.ServiceList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+	}
+	for _, service := range sl.Items {
+		if !uids[service.ObjectMeta.UID] {
 			serviceList.Items = append(serviceList.Items, service)
 		}
 	}
@@ -72,16 +242,45 @@ func GetAnnotatedServices(sl corev1.ServiceList) corev1.ServiceList {
 	return serviceList
 }
 
-// NewIngressList calculates a list of `Ingress`s from the annotations
-func NewIngressList(configs []ingressConfig) v1beta1.IngressList {
+// NewIngressList calculates a list of `Ingress`s from the annotations, with paths translated for the target controller
+func NewIngressList(configs []ingressConfig, o Options) v1beta1.IngressList {
 	ingresses := []v1beta1.Ingress{}
 	for _, config := range configs {
+		useRegex := config.usesRegex(o.controller())
 		rules := []v1beta1.IngressRule{}
 		for _, hostConfig := range config.HostConfigs {
-			rule := newRule(hostConfig)
+			rule := newRule(hostConfig, useRegex, o.controller())
 			rules = append(rules, rule)
 		}
 		ingress := newIngress(config.Name, rules)
+		configured := sortedKeys(config.Annotations)
+		for _, key := range configured {
+			ingress.ObjectMeta.Annotations[key] = config.Annotations[key]
+		}
+		if config.Class != "" {
+			ingress.ObjectMeta.Annotations[ingressClassAnnotationKey] = config.Class
+			configured = append(configured, ingressClassAnnotationKey)
+		}
+		if len(configured) > 0 {
+			ingress.ObjectMeta.Annotations[configuredAnnotationsKey] = strings.Join(configured, ",")
+		}
+		if o.controller() == ControllerNginx {
+			// always set, so that it's turned off again when the last regular expression is removed
+			ingress.ObjectMeta.Annotations[nginxUseRegexAnnotationKey] = strconv.FormatBool(useRegex)
+		}
+		if config.Canary != nil {
+			ingress.ObjectMeta.Annotations[nginxCanaryAnnotationKey] = "true"
+			ingress.ObjectMeta.Annotations[nginxCanaryWeightAnnotationKey] = strconv.Itoa(*config.Canary)
+		}
+		if config.Backend != nil {
+			ingress.Spec.Backend = newBackend(*config.Backend)
+		}
+		for _, tls := range config.TLSConfigs {
+			ingress.Spec.TLS = append(ingress.Spec.TLS, v1beta1.IngressTLS{
+				Hosts:      tls.Hosts,
+				SecretName: tls.SecretName,
+			})
+		}
 		ingresses = append(ingresses, ingress)
 	}
 
@@ -94,19 +293,23 @@ func NewIngressList(configs []ingressConfig) v1beta1.IngressList {
 	}
 }
 
-// List all `Ingress` objects
-func GetAllIngresses() (error, v1beta1.IngressList) {
+// List all `Ingress` objects from the cache
+func GetAllIngresses(lister extensionslisters.IngressNamespaceLister) (error, v1beta1.IngressList) {
 	ingressList := v1beta1.IngressList{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Ingress",
 			APIVersion: "extensions/v1beta1",
 		},
 	}
-	err := sdk.List("default", &ingressList) // TODO set the namespace via config
+	ingresses, err := lister.List(labels.Everything())
 	if err != nil {
 		logrus.Errorf("Failed to query Ingresses : %v", err)
 		return err, v1beta1.IngressList{}
 	}
+	sort.Slice(ingresses, func(i, j int) bool { return ingresses[i].Name < ingresses[j].Name })
+	for _, ingress := range ingresses {
+		ingressList.Items = append(ingressList.Items, *FromCache(ingress).(*v1beta1.Ingress))
+	}
 
 	return nil, ingressList
 }
@@ -119,7 +322,7 @@ func GetAnnotatedIngresses(sl v1beta1.IngressList) v1beta1.IngressList {
 		},
 	}
 	for _, ingress := range sl.Items {
-		if ingress.ObjectMeta.Annotations[ingressAnnotationKey] == "true" {
+		if IsManagedIngress(ingress) {
 			ingressList.Items = append(ingressList.Items, ingress)
 		}
 	}
@@ -127,6 +330,16 @@ func GetAnnotatedIngresses(sl v1beta1.IngressList) v1beta1.IngressList {
 	return ingressList
 }
 
+// IsManagedIngress reports whether the `Ingress` was created by us
+func IsManagedIngress(ingress v1beta1.Ingress) bool {
+	return ingress.ObjectMeta.Annotations[ingressAnnotationKey] == "true"
+}
+
+// IsPinnedIngress reports whether the `Ingress` has been annotated to never be deleted by us
+func IsPinnedIngress(ingress v1beta1.Ingress) bool {
+	return ingress.ObjectMeta.Annotations[pinnedAnnotationKey] == "true"
+}
+
 func GetOrphanedIngresses(desired, observed v1beta1.IngressList) v1beta1.IngressList {
 	orphaned := v1beta1.IngressList{
 		TypeMeta: metav1.TypeMeta{
@@ -149,39 +362,109 @@ func GetOrphanedIngresses(desired, observed v1beta1.IngressList) v1beta1.Ingress
 	return orphaned
 }
 
-//   expects all services passed to be annotated
-func BuildConfigs(sl corev1.ServiceList) (error, []ingressConfig) {
+// expects all services passed to be annotated, and leaves out the hosts and routes with host policy violations.
+// configs are returned in the order their names first appear, as are hosts and TLS secrets.
+// A host's default backend is its last path, and the first default backend for an `Ingress` wins, as does the first canary weight,
+// the first class, and the first value of each `Ingress` annotation, which FindConflicts makes sure all the `Service`s agree on
+func BuildConfigs(sl corev1.ServiceList, violations []Violation, o Options) (error, []ingressConfig) {
+	names := []string{}
 	nameMap := map[string][]yamlConfig{}
 	for _, service := range sl.Items {
-		yc := yamlConfig{}
-		err := yaml.Unmarshal([]byte(service.ObjectMeta.Annotations[configAnnotationKey]), &yc)
+		err, routes := readRoutes(service, false, o)
 		if err != nil {
 			return err, []ingressConfig{}
 		}
-		nameMap[yc.Name] = append(nameMap[yc.Name], yc)
+		for _, yc := range allowedRoutes(service, routes, violations) {
+			if _, ok := nameMap[yc.ingressName()]; !ok {
+				names = append(names, yc.ingressName())
+			}
+			nameMap[yc.ingressName()] = append(nameMap[yc.ingressName()], yc)
+		}
 	}
 
 	configs := []ingressConfig{}
 
-	for name, yConfigs := range nameMap {
+	for _, name := range names {
+		hosts := []string{}
 		hostMap := map[string][]pathConfig{}
-		for _, yConfig := range yConfigs {
-			hostMap[yConfig.Host] = append(hostMap[yConfig.Host], pathConfig{
-				Path:    yConfig.Path,
-				Service: yConfig.Service,
-				Port:    yConfig.Port,
-			})
+		hostDefaults := map[string][]pathConfig{}
+		secrets := []string{}
+		secretHosts := map[string][]string{}
+		var backend *pathConfig
+		var canary *int
+		class := ""
+		var annotations map[string]string
+		for _, yConfig := range nameMap[name] {
+			if yConfig.Canary && canary == nil {
+				weight := yConfig.CanaryWeight
+				canary = &weight
+			}
+			if class == "" {
+				class = yConfig.IngressClass
+			}
+			for key, value := range yConfig.IngressAnnotations {
+				if annotations == nil {
+					annotations = map[string]string{}
+				}
+				if _, ok := annotations[key]; !ok {
+					annotations[key] = value
+				}
+			}
+			pc := pathConfig{
+				Path:     yConfig.Path,
+				PathType: yConfig.PathType,
+				Service:  yConfig.Service,
+				Port:     yConfig.Port,
+			}
+			if yConfig.DefaultBackend && len(yConfig.Hosts) == 0 {
+				if backend == nil {
+					backend = &pc
+				}
+				continue
+			}
+			for _, host := range yConfig.routeHosts() {
+				if _, ok := hostMap[host]; !ok {
+					hosts = append(hosts, host)
+					hostMap[host] = []pathConfig{}
+				}
+				if yConfig.DefaultBackend {
+					hostDefaults[host] = append(hostDefaults[host], pc)
+					continue
+				}
+				hostMap[host] = append(hostMap[host], pc)
+			}
+			if yConfig.TLSSecret == "" {
+				continue
+			}
+			if _, ok := secretHosts[yConfig.TLSSecret]; !ok {
+				secrets = append(secrets, yConfig.TLSSecret)
+				secretHosts[yConfig.TLSSecret] = []string{}
+			}
+			for _, host := range yConfig.Hosts {
+				if !containsString(secretHosts[yConfig.TLSSecret], host) {
+					secretHosts[yConfig.TLSSecret] = append(secretHosts[yConfig.TLSSecret], host)
+				}
+			}
 		}
 
 		hostConfigs := []hostConfig{}
-		for hostName, pathConfigs := range hostMap {
-			hc := hostConfig{Host: hostName, PathConfigs: pathConfigs}
+		for _, hostName := range hosts {
+			hc := hostConfig{Host: hostName, PathConfigs: append(hostMap[hostName], hostDefaults[hostName]...)}
 			hostConfigs = append(hostConfigs, hc)
 		}
+		var tlsConfigs []tlsConfig
+		for _, secret := range secrets {
+			tlsConfigs = append(tlsConfigs, tlsConfig{SecretName: secret, Hosts: secretHosts[secret]})
+		}
 
 		ic := ingressConfig{
 			Name:        name,
 			HostConfigs: hostConfigs,
+			TLSConfigs:  tlsConfigs,
+			Backend:     backend,
+			Canary:      canary,
+			Class:       class,
+			Annotations: annotations,
 		}
 		configs = append(configs, ic)
 	}
@@ -189,6 +472,40 @@ func BuildConfigs(sl corev1.ServiceList) (error, []ingressConfig) {
 	return nil, configs
 }
 
+// FindIngress looks up an `Ingress` in the list by name
+func FindIngress(il v1beta1.IngressList, name string) (v1beta1.Ingress, bool) {
+	for _, ingress := range il.Items {
+		if ingress.ObjectMeta.Name == name {
+			return ingress, true
+		}
+	}
+
+	return v1beta1.Ingress{}, false
+}
+
+// IngressChanged reports whether applying desired over observed would change anything we manage
+func IngressChanged(desired, observed v1beta1.Ingress) bool {
+	if !reflect.DeepEqual(desired.Spec, observed.Spec) {
+		return true
+	}
+	for key, value := range desired.ObjectMeta.Annotations {
+		if observed.ObjectMeta.Annotations[key] != value {
+			return true
+		}
+	}
+	// annotations set from config annotations that have since been removed
+	for _, key := range strings.Split(observed.ObjectMeta.Annotations[configuredAnnotationsKey], ",") {
+		if _, ok := desired.ObjectMeta.Annotations[key]; key != "" && !ok {
+			return true
+		}
+	}
+	if len(desired.ObjectMeta.OwnerReferences) > 0 && !reflect.DeepEqual(desired.ObjectMeta.OwnerReferences, observed.ObjectMeta.OwnerReferences) {
+		return true
+	}
+
+	return false
+}
+
 func newIngress(name string, rules []v1beta1.IngressRule) v1beta1.Ingress {
 	return v1beta1.Ingress{
 		TypeMeta: metav1.TypeMeta{
@@ -208,17 +525,21 @@ func newIngress(name string, rules []v1beta1.IngressRule) v1beta1.Ingress {
 	}
 }
 
-func newRule(config hostConfig) v1beta1.IngressRule {
+// newRule builds the rule for a host, with its paths escaped when the `Ingress`'s paths are regular expressions
+func newRule(config hostConfig, useRegex bool, controller string) v1beta1.IngressRule {
 	pathConfigs := []v1beta1.HTTPIngressPath{}
 	for _, pathConfig := range config.PathConfigs {
-		pc := v1beta1.HTTPIngressPath{
-			Path: pathConfig.Path,
-			Backend: v1beta1.IngressBackend{
-				ServiceName: pathConfig.Service,
-				ServicePort: intstr.FromInt(pathConfig.Port),
-			},
+		paths, regex := translatePath(controller, pathConfig.PathType, pathConfig.Path)
+		for _, path := range paths {
+			if useRegex && !regex {
+				path = regexp.QuoteMeta(path)
+			}
+			pc := v1beta1.HTTPIngressPath{
+				Path:    path,
+				Backend: *newBackend(pathConfig),
+			}
+			pathConfigs = append(pathConfigs, pc)
 		}
-		pathConfigs = append(pathConfigs, pc)
 	}
 	return v1beta1.IngressRule{
 		Host: config.Host,
@@ -229,3 +550,44 @@ func newRule(config hostConfig) v1beta1.IngressRule {
 		},
 	}
 }
+
+func newBackend(config pathConfig) *v1beta1.IngressBackend {
+	return &v1beta1.IngressBackend{
+		ServiceName: config.Service,
+		ServicePort: intstr.FromInt(config.Port),
+	}
+}
+
+// reservedIngressAnnotation reports why config annotations can't set the `Ingress` annotation, if they can't
+func reservedIngressAnnotation(key string) (string, bool) {
+	switch {
+	case strings.HasPrefix(key, annotationPrefix):
+		return "is reserved for the controller", true
+	case key == ingressClassAnnotationKey:
+		return "is set with ingressClass", true
+	case key == nginxUseRegexAnnotationKey || key == nginxCanaryAnnotationKey || key == nginxCanaryWeightAnnotationKey:
+		return "is set by the controller", true
+	}
+
+	return "", false
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -7,7 +7,11 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	extensionslisters "k8s.io/client-go/listers/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
 )
 
 const (
@@ -33,6 +37,126 @@ service: web2
 port: 80`
 )
 
+func TestGetAllServices(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	serviceList := newServiceList()
+	for i := len(serviceList.Items) - 1; i >= 0; i-- {
+		indexer.Add(&serviceList.Items[i])
+	}
+	err, result := GetAllServices(corelisters.NewServiceLister(indexer).Services("default"))
+	if err != nil {
+		t.Errorf("Error listing Services: %v\n", err)
+	}
+	names := []string{}
+	for _, service := range result.Items {
+		names = append(names, service.Name)
+	}
+	expected := []string{"another", "one", "prod", "three", "two"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, names)
+	}
+}
+
+func TestGetAllIngresses(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	ingressList := newIngressList()
+	for i := range ingressList.Items {
+		indexer.Add(&ingressList.Items[i])
+	}
+	err, result := GetAllIngresses(extensionslisters.NewIngressLister(indexer).Ingresses("default"))
+	if err != nil {
+		t.Errorf("Error listing Ingresses: %v\n", err)
+	}
+	// sorted by name
+	expected := newIngressList()
+	items := expected.Items
+	expected.Items = []v1beta1.Ingress{items[0], items[2], items[1]}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestIngressNames(t *testing.T) {
+	serviceList := newServiceList()
+	err, result := IngressNames(serviceList.Items[1])
+	if err != nil {
+		t.Errorf("Error reading ingress names: %v\n", err)
+	}
+	expected := []string{"staging"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// not annotated
+	err, result = IngressNames(serviceList.Items[4])
+	if err != nil {
+		t.Errorf("Error reading ingress names: %v\n", err)
+	}
+	if len(result) != 0 {
+		t.Errorf("Expected no ingress names, got %v", result)
+	}
+
+	// unreadable annotation
+	service := serviceList.Items[0]
+	service.ObjectMeta.Annotations[ConfigAnnotationKey] = "name: [production"
+	err, _ = IngressNames(service)
+	if err == nil {
+		t.Errorf("Expected an error for an unreadable annotation")
+	}
+}
+
+func TestGetServicesForIngress(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		IngressNameIndex: IngressNameIndexFunc,
+	})
+	serviceList := newServiceList()
+	for i := range serviceList.Items {
+		indexer.Add(&serviceList.Items[i])
+	}
+	err, result := GetServicesForIngress(indexer, "staging")
+	if err != nil {
+		t.Errorf("Error listing Services: %v\n", err)
+	}
+	names := []string{}
+	for _, service := range result.Items {
+		names = append(names, service.Name)
+	}
+	expected := []string{"one", "three", "two"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, names)
+	}
+}
+
+func TestExcludeServices(t *testing.T) {
+	serviceList := newServiceList()
+	for i := range serviceList.Items {
+		serviceList.Items[i].ObjectMeta.UID = types.UID(serviceList.Items[i].Name)
+	}
+
+	// Service "one" was the only contributor to that.example.com
+	result := ExcludeServices(GetAnnotatedServices(serviceList), map[types.UID]bool{"one": true})
+	err, configs := BuildConfigs(result, nil, Options{})
+	if err != nil {
+		t.Errorf("Error building ingress configs: %v\n", err)
+	}
+	expected := expectedIngressConfigs()
+	expected[1].HostConfigs = expected[1].HostConfigs[1:]
+	if !reflect.DeepEqual(expected, configs) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, configs)
+	}
+
+	// Service "prod" was the only contributor to the production Ingress
+	result = ExcludeServices(GetAnnotatedServices(serviceList), map[types.UID]bool{"prod": true})
+	err, configs = BuildConfigs(result, nil, Options{})
+	if err != nil {
+		t.Errorf("Error building ingress configs: %v\n", err)
+	}
+	expected = expectedIngressConfigs()[1:]
+	if !reflect.DeepEqual(expected, configs) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, configs)
+	}
+}
+
 func TestGetAnnotatedServices(t *testing.T) {
 	serviceList := newServiceList()
 	result := GetAnnotatedServices(serviceList)
@@ -45,7 +169,7 @@ func TestGetAnnotatedServices(t *testing.T) {
 func TestBuildConfigs(t *testing.T) {
 	serviceList := newServiceList()
 	annotatedList := GetAnnotatedServices(serviceList)
-	err, result := BuildConfigs(annotatedList)
+	err, result := BuildConfigs(annotatedList, nil, Options{})
 	if err != nil {
 		t.Errorf("Error building ingress configs: %v\n", err)
 	}
@@ -58,17 +182,129 @@ func TestBuildConfigs(t *testing.T) {
 func TestNewIngressList(t *testing.T) {
 	serviceList := newServiceList()
 	annotatedList := GetAnnotatedServices(serviceList)
-	err, configs := BuildConfigs(annotatedList)
+	err, configs := BuildConfigs(annotatedList, nil, Options{})
 	if err != nil {
 		t.Errorf("Error building ingress configs: %v\n", err)
 	}
-	result := NewIngressList(configs)
+	result := NewIngressList(configs, Options{})
 	expected := expectedIngressList()
 	if !reflect.DeepEqual(expected, result) {
 		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
 	}
 }
 
+func TestNewIngressListHostsAndTLS(t *testing.T) {
+	web := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\nhosts: [web.example.com, \"*.example.com\"]\npath: /\ntls:\n  secretName: example-tls\nbackend:\n  service: web\n  port: 80\n")
+	api := newAnnotatedService("api", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /api\ntls:\n  secretName: example-tls\nbackend:\n  service: api\n  port: 80\n")
+	err, configs := BuildConfigs(corev1.ServiceList{Items: []corev1.Service{web, api}}, nil, Options{})
+	if err != nil {
+		t.Errorf("Error building ingress configs: %v\n", err)
+	}
+	result := NewIngressList(configs, Options{})
+	if len(result.Items) != 1 {
+		t.Fatalf("Expected one Ingress, got %v", result.Items)
+	}
+
+	hosts := []string{}
+	for _, rule := range result.Items[0].Spec.Rules {
+		hosts = append(hosts, rule.Host)
+	}
+	expectedHosts := []string{"web.example.com", "*.example.com"}
+	if !reflect.DeepEqual(expectedHosts, hosts) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expectedHosts, hosts)
+	}
+	expectedTLS := []v1beta1.IngressTLS{{Hosts: []string{"web.example.com", "*.example.com"}, SecretName: "example-tls"}}
+	if !reflect.DeepEqual(expectedTLS, result.Items[0].Spec.TLS) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expectedTLS, result.Items[0].Spec.TLS)
+	}
+}
+
+func TestNewIngressListDefaultBackend(t *testing.T) {
+	web := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /web\nbackend:\n  service: web\n  port: 80\n")
+	fallback := newAnnotatedService("fallback", "apiVersion: v1beta1\ningress: prod\ndefaultBackend: true\nbackend:\n  service: fallback\n  port: 8080\n")
+	catchAll := newAnnotatedService("catch-all", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\ndefaultBackend: true\nbackend:\n  service: catch-all\n  port: 80\n")
+	api := newAnnotatedService("api", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /api\nbackend:\n  service: api\n  port: 80\n")
+	err, configs := BuildConfigs(corev1.ServiceList{Items: []corev1.Service{web, fallback, catchAll, api}}, nil, Options{})
+	if err != nil {
+		t.Errorf("Error building ingress configs: %v\n", err)
+	}
+	result := NewIngressList(configs, Options{})
+	if len(result.Items) != 1 {
+		t.Fatalf("Expected one Ingress, got %v", result.Items)
+	}
+
+	expectedBackend := &v1beta1.IngressBackend{ServiceName: "fallback", ServicePort: intstr.FromInt(8080)}
+	if !reflect.DeepEqual(expectedBackend, result.Items[0].Spec.Backend) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expectedBackend, result.Items[0].Spec.Backend)
+	}
+	// the catch-all path is last, after the host's other paths
+	paths := []string{}
+	for _, path := range result.Items[0].Spec.Rules[0].HTTP.Paths {
+		paths = append(paths, path.Path+" "+path.Backend.ServiceName)
+	}
+	expectedPaths := []string{"/web web", "/api api", " catch-all"}
+	if len(result.Items[0].Spec.Rules) != 1 || !reflect.DeepEqual(expectedPaths, paths) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expectedPaths, result.Items[0].Spec.Rules)
+	}
+}
+
+func TestNewIngressListCanary(t *testing.T) {
+	nginx := Options{TargetController: ControllerNginx}
+	web := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /\nbackend:\n  service: web\n  port: 80\n")
+	canary := newAnnotatedService("web-next", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /\ncanary:\n  weight: 20\nbackend:\n  service: web-next\n  port: 80\n")
+	err, configs := BuildConfigs(corev1.ServiceList{Items: []corev1.Service{web, canary}}, nil, nginx)
+	if err != nil {
+		t.Errorf("Error building ingress configs: %v\n", err)
+	}
+	result := NewIngressList(configs, nginx)
+	names := []string{}
+	for _, ingress := range result.Items {
+		names = append(names, ingress.Name)
+	}
+	expectedNames := []string{"prod", "prod-canary-web-next"}
+	if !reflect.DeepEqual(expectedNames, names) {
+		t.Fatalf("Expected:\n%v\nGot:\n%v\n", expectedNames, names)
+	}
+
+	if _, ok := result.Items[0].ObjectMeta.Annotations[nginxCanaryAnnotationKey]; ok {
+		t.Errorf("Expected no canary annotation on the primary Ingress, got %v", result.Items[0].ObjectMeta.Annotations)
+	}
+	annotations := result.Items[1].ObjectMeta.Annotations
+	if annotations[nginxCanaryAnnotationKey] != "true" || annotations[nginxCanaryWeightAnnotationKey] != "20" {
+		t.Errorf("Expected canary annotations with weight 20, got %v", annotations)
+	}
+	backend := result.Items[1].Spec.Rules[0].HTTP.Paths[0].Backend
+	if backend.ServiceName != "web-next" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "web-next", backend.ServiceName)
+	}
+
+	err, ingressNames := IngressNames(canary)
+	if err != nil || !reflect.DeepEqual([]string{"prod-canary-web-next"}, ingressNames) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", []string{"prod-canary-web-next"}, ingressNames)
+	}
+}
+
+func TestNewIngressListIngressSettings(t *testing.T) {
+	web := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\ningressClass: public\ningressAnnotations:\n  example.com/a: web\nbackend:\n  service: web\n  port: 80\n")
+	api := newAnnotatedService("api", "apiVersion: v1beta1\ningress: prod\nhost: api.example.com\ningressClass: internal\ningressAnnotations:\n  example.com/a: api\n  example.com/b: api\nbackend:\n  service: api\n  port: 80\n")
+	err, configs := BuildConfigs(corev1.ServiceList{Items: []corev1.Service{web, api}}, nil, Options{})
+	if err != nil {
+		t.Errorf("Error building ingress configs: %v\n", err)
+	}
+	result := NewIngressList(configs, Options{})
+	// the first value of each wins
+	expected := map[string]string{
+		ingressAnnotationKey:      "true",
+		"example.com/a":           "web",
+		"example.com/b":           "api",
+		ingressClassAnnotationKey: "public",
+		configuredAnnotationsKey:  "example.com/a,example.com/b,kubernetes.io/ingress.class",
+	}
+	if !reflect.DeepEqual(expected, result.Items[0].ObjectMeta.Annotations) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result.Items[0].ObjectMeta.Annotations)
+	}
+}
+
 func TestGetAnnotatedIngresses(t *testing.T) {
 	ingressList := newIngressList()
 	result := GetAnnotatedIngresses(ingressList)
@@ -127,6 +363,73 @@ func TestGetOrphanedIngresses(t *testing.T) {
 	}
 }
 
+func TestIsPinnedIngress(t *testing.T) {
+	ingress := aIngress()
+	if IsPinnedIngress(ingress) {
+		t.Errorf("Expected Ingress not to be pinned")
+	}
+	ingress.ObjectMeta.Annotations[pinnedAnnotationKey] = "true"
+	if !IsPinnedIngress(ingress) {
+		t.Errorf("Expected Ingress to be pinned")
+	}
+}
+
+func TestFindIngress(t *testing.T) {
+	ingressList := expectedIngressList()
+	result, found := FindIngress(ingressList, "staging")
+	if !found {
+		t.Errorf("Expected to find Ingress 'staging'")
+	}
+	expected := ingressList.Items[1]
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	_, found = FindIngress(ingressList, "nope")
+	if found {
+		t.Errorf("Expected not to find Ingress 'nope'")
+	}
+}
+
+func TestIngressChanged(t *testing.T) {
+	desired := aIngress()
+
+	// identical
+	observed := aIngress()
+	observed.ObjectMeta.ResourceVersion = "12345"
+	if IngressChanged(desired, observed) {
+		t.Errorf("Expected no change for identical Ingresses")
+	}
+
+	// extra observed annotations are left alone
+	observed.ObjectMeta.Annotations["kubernetes.io/ingress.class"] = "nginx"
+	if IngressChanged(desired, observed) {
+		t.Errorf("Expected no change for extra observed annotations")
+	}
+
+	// missing managed annotation
+	observed = aIngress()
+	observed.ObjectMeta.Annotations = map[string]string{}
+	if !IngressChanged(desired, observed) {
+		t.Errorf("Expected change for missing managed annotation")
+	}
+
+	// different spec
+	observed = aIngress()
+	observed.Spec.Rules[0].Host = "b-ingress.example.com"
+	if !IngressChanged(desired, observed) {
+		t.Errorf("Expected change for different spec")
+	}
+
+	// an annotation set from a config annotation that has since been removed
+	observed = aIngress()
+	observed.ObjectMeta.Annotations["example.com/team"] = "web"
+	observed.ObjectMeta.Annotations[configuredAnnotationsKey] = "example.com/team"
+	if !IngressChanged(desired, observed) {
+		t.Errorf("Expected change for a removed configured annotation")
+	}
+}
+
 func expectedIngressConfigs() []ingressConfig {
 	return []ingressConfig{
 		{
@@ -193,7 +496,7 @@ func newServiceList() corev1.ServiceList {
 					Name:      "prod",
 					Namespace: "default",
 					Annotations: map[string]string{
-						configAnnotationKey: prodConfig,
+						ConfigAnnotationKey: prodConfig,
 					},
 				},
 				Spec: corev1.ServiceSpec{
@@ -214,7 +517,7 @@ func newServiceList() corev1.ServiceList {
 					Name:      "one",
 					Namespace: "default",
 					Annotations: map[string]string{
-						configAnnotationKey: stagingConfig,
+						ConfigAnnotationKey: stagingConfig,
 					},
 				},
 				Spec: corev1.ServiceSpec{
@@ -235,7 +538,7 @@ func newServiceList() corev1.ServiceList {
 					Name:      "two",
 					Namespace: "default",
 					Annotations: map[string]string{
-						configAnnotationKey: stagingConfig2,
+						ConfigAnnotationKey: stagingConfig2,
 					},
 				},
 				Spec: corev1.ServiceSpec{
@@ -256,7 +559,7 @@ func newServiceList() corev1.ServiceList {
 					Name:      "three",
 					Namespace: "default",
 					Annotations: map[string]string{
-						configAnnotationKey: stagingConfig3,
+						ConfigAnnotationKey: stagingConfig3,
 					},
 				},
 				Spec: corev1.ServiceSpec{
@@ -306,7 +609,7 @@ func expectedServiceList() corev1.ServiceList {
 					Name:      "prod",
 					Namespace: "default",
 					Annotations: map[string]string{
-						configAnnotationKey: prodConfig,
+						ConfigAnnotationKey: prodConfig,
 					},
 				},
 				Spec: corev1.ServiceSpec{
@@ -327,7 +630,7 @@ func expectedServiceList() corev1.ServiceList {
 					Name:      "one",
 					Namespace: "default",
 					Annotations: map[string]string{
-						configAnnotationKey: stagingConfig,
+						ConfigAnnotationKey: stagingConfig,
 					},
 				},
 				Spec: corev1.ServiceSpec{
@@ -348,7 +651,7 @@ func expectedServiceList() corev1.ServiceList {
 					Name:      "two",
 					Namespace: "default",
 					Annotations: map[string]string{
-						configAnnotationKey: stagingConfig2,
+						ConfigAnnotationKey: stagingConfig2,
 					},
 				},
 				Spec: corev1.ServiceSpec{
@@ -369,7 +672,7 @@ func expectedServiceList() corev1.ServiceList {
 					Name:      "three",
 					Namespace: "default",
 					Annotations: map[string]string{
-						configAnnotationKey: stagingConfig3,
+						ConfigAnnotationKey: stagingConfig3,
 					},
 				},
 				Spec: corev1.ServiceSpec{
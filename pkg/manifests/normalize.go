@@ -0,0 +1,52 @@
+package manifests
+
+import (
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NormalizeConfig rewrites a `Service`'s config annotation in canonical form, the latest version with sorted keys and cleaned paths,
+// filling in the `Service`'s own name, its sole port, and the ingress name given unless it's empty.
+// Returns an empty string when the `Service` isn't annotated
+func NormalizeConfig(service corev1.Service, ingressName string) (error, string) {
+	value := service.ObjectMeta.Annotations[ConfigAnnotationKey]
+	if value == "" {
+		return nil, ""
+	}
+	err, yc := parseConfig(value, true)
+	if err != nil {
+		return err, ""
+	}
+
+	if yc.Name == "" {
+		yc.Name = ingressName
+	}
+	if yc.Service == "" {
+		yc.Service = service.ObjectMeta.Name
+	}
+	if yc.Port == 0 && len(service.Spec.Ports) == 1 {
+		yc.Port = int(service.Spec.Ports[0].Port)
+	}
+	// regular expressions are left as written
+	if yc.PathType != PathTypeImplementationSpecific {
+		yc.Path = normalizePath(yc.Path)
+	}
+
+	return marshalConfig(yc)
+}
+
+// normalizePath collapses repeated slashes and dot segments, keeping a trailing slash
+func normalizePath(p string) string {
+	// templates are left as written
+	if p == "" || !strings.HasPrefix(p, "/") || isTemplate(p) {
+		return p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
@@ -0,0 +1,83 @@
+package manifests
+
+import (
+	"testing"
+)
+
+func TestNormalizeConfig(t *testing.T) {
+	service := newAnnotatedService("web", "service: web\npath: //web/./api/\nhost: web.example.com\n", 8080)
+	err, result := NormalizeConfig(service, "shared")
+	if err != nil {
+		t.Errorf("Error normalizing config: %v", err)
+	}
+	expected := "apiVersion: v1beta1\nbackend:\n  port: 8080\n  service: web\nhost: web.example.com\ningress: shared\npath: /web/api/\n"
+	if result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// already canonical
+	service = newAnnotatedService("web", expected, 8080, 8443)
+	err, result = NormalizeConfig(service, "")
+	if err != nil || result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// converted from JSON
+	service = newAnnotatedService("web", `{"name": "shared", "host": "web.example.com", "path": "/web/api/", "service": "web", "port": 8080}`)
+	err, result = NormalizeConfig(service, "")
+	if err != nil || result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// nothing to default from
+	service = newAnnotatedService("web", "host: web.example.com\n", 8080, 8443)
+	err, result = NormalizeConfig(service, "")
+	expected = "apiVersion: v1beta1\nbackend:\n  service: web\nhost: web.example.com\n"
+	if err != nil || result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// more than one host, and TLS
+	service = newAnnotatedService("web", "apiVersion: v1beta1\nhost: web.example.com\nhosts: [\"*.example.com\"]\ntls:\n  secretName: example-tls\n", 8080)
+	err, result = NormalizeConfig(service, "")
+	expected = "apiVersion: v1beta1\nbackend:\n  port: 8080\n  service: web\nhosts:\n- web.example.com\n- '*.example.com'\ntls:\n  secretName: example-tls\n"
+	if err != nil || result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// Ingress settings
+	service = newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\ningressClass: public\ningressAnnotations:\n  example.com/team: web\n", 8080)
+	err, result = NormalizeConfig(service, "")
+	expected = "apiVersion: v1beta1\nbackend:\n  port: 8080\n  service: web\ningress: prod\ningressAnnotations:\n  example.com/team: web\ningressClass: public\n"
+	if err != nil || result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// a default backend
+	service = newAnnotatedService("web", `{"apiVersion": "v1beta1", "ingress": "prod", "defaultBackend": true}`, 8080)
+	err, result = NormalizeConfig(service, "")
+	expected = "apiVersion: v1beta1\nbackend:\n  port: 8080\n  service: web\ndefaultBackend: true\ningress: prod\n"
+	if err != nil || result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// a canary, with a path regular expression
+	service = newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\npath: /api//v[0-9]+\npathType: ImplementationSpecific\ncanary:\n  weight: 0\n", 8080)
+	err, result = NormalizeConfig(service, "")
+	expected = "apiVersion: v1beta1\nbackend:\n  port: 8080\n  service: web\ncanary:\n  weight: 0\ningress: prod\npath: /api//v[0-9]+\npathType: ImplementationSpecific\n"
+	if err != nil || result != expected {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	service = newAnnotatedService("web", "hots: web.example.com\n")
+	err, _ = NormalizeConfig(service, "")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown field")
+	}
+
+	service = newAnnotatedService("web", "")
+	err, result = NormalizeConfig(service, "shared")
+	if err != nil || result != "" {
+		t.Errorf("Expected nothing for an unannotated Service, got %q", result)
+	}
+}
@@ -0,0 +1,38 @@
+package manifests
+
+import (
+	"fmt"
+)
+
+// Options are how `Ingress`s are built, set from the controller's or a subcommand's flags
+type Options struct {
+	// TargetController is the ingress controller paths are translated for, ControllerGCE when empty
+	TargetController string
+	// TemplateValues are what host and path templates are rendered with
+	TemplateValues TemplateValues
+	// MaxRulesPerIngress is how many host rules an `Ingress` may have before it's split into shards, 0 for no limit
+	MaxRulesPerIngress int
+}
+
+// Validate returns an error for settings that aren't supported
+func (o Options) Validate() error {
+	switch o.TargetController {
+	case "", ControllerGCE, ControllerNginx:
+	default:
+		return fmt.Errorf("unknown ingress controller '%s', expected %s or %s", o.TargetController, ControllerGCE, ControllerNginx)
+	}
+	if o.MaxRulesPerIngress < 0 {
+		return fmt.Errorf("max rules per Ingress must be 0 or more, not %d", o.MaxRulesPerIngress)
+	}
+
+	return nil
+}
+
+// controller is the ingress controller paths are translated for
+func (o Options) controller() string {
+	if o.TargetController == "" {
+		return ControllerGCE
+	}
+
+	return o.TargetController
+}
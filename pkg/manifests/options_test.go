@@ -0,0 +1,21 @@
+package manifests
+
+import (
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	for _, o := range []Options{{}, {TargetController: ControllerGCE}, {TargetController: ControllerNginx, MaxRulesPerIngress: 50}} {
+		if err := o.Validate(); err != nil {
+			t.Errorf("Expected no error for %+v, got %v", o, err)
+		}
+	}
+	for _, o := range []Options{{TargetController: "traefik"}, {MaxRulesPerIngress: -1}} {
+		if err := o.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", o)
+		}
+	}
+	if controller := (Options{}).controller(); controller != ControllerGCE {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", ControllerGCE, controller)
+	}
+}
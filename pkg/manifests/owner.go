@@ -0,0 +1,109 @@
+package manifests
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OwnerLabelKey labels the `ConfigMap`s that own the `Ingress`s we create
+const OwnerLabelKey = "ingress-controller-controller.alpha.davidamick.com/owner"
+
+// finalizerName is added to annotated `Service`s, so we see their deletion before they disappear
+const finalizerName = "ingress-controller-controller.alpha.davidamick.com/routes"
+
+// OwnerName is the name of the `ConfigMap` owning the named `Ingress`
+func OwnerName(ingressName string) string {
+	return "icc-" + ingressName
+}
+
+// NewOwner builds the `ConfigMap` owning the named `Ingress`,
+// deleting it has Kubernetes garbage collect the `Ingress`
+func NewOwner(ingressName string) corev1.ConfigMap {
+	return corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      OwnerName(ingressName),
+			Namespace: "default",
+			Labels: map[string]string{
+				OwnerLabelKey: "true",
+			},
+			Annotations: map[string]string{
+				ingressAnnotationKey: "true",
+			},
+		},
+		Data: map[string]string{
+			"ingress": ingressName,
+		},
+	}
+}
+
+// IsOwner reports whether the `ConfigMap` is one of ours, rather than a user's that happens to have the name
+func IsOwner(configMap corev1.ConfigMap) bool {
+	return configMap.ObjectMeta.Labels[OwnerLabelKey] == "true"
+}
+
+// SetOwner makes the `ConfigMap` the controlling owner of the `Ingress`
+func SetOwner(ingress *v1beta1.Ingress, owner corev1.ConfigMap) {
+	controller := true
+	blockOwnerDeletion := true
+	ingress.ObjectMeta.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion:         "v1",
+			Kind:               "ConfigMap",
+			Name:               owner.ObjectMeta.Name,
+			UID:                owner.ObjectMeta.UID,
+			Controller:         &controller,
+			BlockOwnerDeletion: &blockOwnerDeletion,
+		},
+	}
+}
+
+// HasFinalizer reports whether the `Service` carries our finalizer
+func HasFinalizer(service corev1.Service) bool {
+	for _, finalizer := range service.ObjectMeta.Finalizers {
+		if finalizer == finalizerName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddFinalizer adds our finalizer to the `Service`, if it's missing
+func AddFinalizer(service *corev1.Service) {
+	if !HasFinalizer(*service) {
+		service.ObjectMeta.Finalizers = append(service.ObjectMeta.Finalizers, finalizerName)
+	}
+}
+
+// RemoveFinalizer removes our finalizer from the `Service`, leaving any others
+func RemoveFinalizer(service *corev1.Service) {
+	finalizers := []string{}
+	for _, finalizer := range service.ObjectMeta.Finalizers {
+		if finalizer != finalizerName {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	service.ObjectMeta.Finalizers = finalizers
+}
+
+// ExcludeDeletingServices drops the `Service`s that are waiting on finalizers to be deleted
+func ExcludeDeletingServices(sl corev1.ServiceList) corev1.ServiceList {
+	serviceList := corev1.ServiceList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+	}
+	for _, service := range sl.Items {
+		if service.ObjectMeta.DeletionTimestamp == nil {
+			serviceList.Items = append(serviceList.Items, service)
+		}
+	}
+
+	return serviceList
+}
@@ -0,0 +1,77 @@
+package manifests
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetOwner(t *testing.T) {
+	owner := NewOwner("a-ingress")
+	owner.ObjectMeta.UID = "owner-uid"
+	ingress := aIngress()
+	SetOwner(&ingress, owner)
+
+	references := ingress.ObjectMeta.OwnerReferences
+	if len(references) != 1 {
+		t.Fatalf("Expected 1 owner reference, got %d", len(references))
+	}
+	if references[0].Kind != "ConfigMap" || references[0].Name != "icc-a-ingress" || references[0].UID != "owner-uid" {
+		t.Errorf("Expected reference to ConfigMap 'icc-a-ingress', got %+v", references[0])
+	}
+	if references[0].Controller == nil || !*references[0].Controller {
+		t.Errorf("Expected owner to be the controller")
+	}
+
+	// owners are compared when deciding whether to update
+	observed := aIngress()
+	if !IngressChanged(ingress, observed) {
+		t.Errorf("Expected change for missing owner reference")
+	}
+	SetOwner(&observed, owner)
+	if IngressChanged(ingress, observed) {
+		t.Errorf("Expected no change for the same owner reference")
+	}
+}
+
+func TestFinalizers(t *testing.T) {
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "web",
+			Finalizers: []string{"example.com/other"},
+		},
+	}
+	if HasFinalizer(service) {
+		t.Errorf("Expected no finalizer")
+	}
+
+	AddFinalizer(&service)
+	AddFinalizer(&service)
+	expected := []string{"example.com/other", finalizerName}
+	if !reflect.DeepEqual(expected, service.ObjectMeta.Finalizers) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, service.ObjectMeta.Finalizers)
+	}
+	if !HasFinalizer(service) {
+		t.Errorf("Expected finalizer")
+	}
+
+	RemoveFinalizer(&service)
+	expected = []string{"example.com/other"}
+	if !reflect.DeepEqual(expected, service.ObjectMeta.Finalizers) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, service.ObjectMeta.Finalizers)
+	}
+}
+
+func TestExcludeDeletingServices(t *testing.T) {
+	serviceList := newServiceList()
+	now := metav1.Now()
+	serviceList.Items[0].ObjectMeta.DeletionTimestamp = &now
+	result := ExcludeDeletingServices(serviceList)
+	expected := newServiceList()
+	expected.Items = expected.Items[1:]
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
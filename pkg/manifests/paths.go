@@ -0,0 +1,80 @@
+package manifests
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// PathTypeExact matches the path exactly
+	PathTypeExact = "Exact"
+	// PathTypePrefix matches the path and everything under it
+	PathTypePrefix = "Prefix"
+	// PathTypeImplementationSpecific leaves matching to the ingress controller, the path is a glob for GCE and a regular expression for nginx
+	PathTypeImplementationSpecific = "ImplementationSpecific"
+)
+
+var pathTypes = []string{PathTypeExact, PathTypePrefix, PathTypeImplementationSpecific}
+
+const (
+	// ControllerGCE is the GCE ingress controller, whose paths are globs such as `/*`
+	ControllerGCE = "gce"
+	// ControllerNginx is ingress-nginx, whose paths are prefixes, or regular expressions with use-regex
+	ControllerNginx = "nginx"
+)
+
+const nginxUseRegexAnnotationKey = "nginx.ingress.kubernetes.io/use-regex"
+const nginxCanaryAnnotationKey = "nginx.ingress.kubernetes.io/canary"
+const nginxCanaryWeightAnnotationKey = "nginx.ingress.kubernetes.io/canary-weight"
+
+// translatePath turns a path of a type into the paths the controller matches it with,
+// and whether they're regular expressions. Paths without a type are as written,
+// except that GCE's `/*` convention is a prefix for nginx
+func translatePath(controller, pathType, path string) ([]string, bool) {
+	if path == "" {
+		return []string{path}, false
+	}
+	if pathType == "" && controller == ControllerNginx && strings.HasSuffix(path, "/*") {
+		pathType = PathTypePrefix
+	}
+	prefix := strings.TrimSuffix(strings.TrimSuffix(path, "*"), "/")
+
+	switch controller {
+	case ControllerGCE:
+		if pathType != PathTypePrefix {
+			return []string{path}, false
+		}
+		// `/foo/*` doesn't match `/foo` itself
+		if prefix == "" {
+			return []string{"/*"}, false
+		}
+		return []string{prefix, prefix + "/*"}, false
+	case ControllerNginx:
+		switch pathType {
+		case PathTypeExact:
+			return []string{regexp.QuoteMeta(path) + "$"}, true
+		case PathTypePrefix:
+			if prefix == "" {
+				return []string{"/"}, false
+			}
+			return []string{prefix}, false
+		case PathTypeImplementationSpecific:
+			return []string{path}, true
+		}
+	}
+
+	return []string{path}, false
+}
+
+// usesRegex reports whether any of the `Ingress`'s paths are regular expressions for the controller
+func (ic ingressConfig) usesRegex(controller string) bool {
+	for _, hc := range ic.HostConfigs {
+		for _, pc := range hc.PathConfigs {
+			if _, regex := translatePath(controller, pc.PathType, pc.Path); regex {
+				return true
+			}
+		}
+	}
+
+	return false
+}
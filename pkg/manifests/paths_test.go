@@ -0,0 +1,105 @@
+package manifests
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTranslatePath(t *testing.T) {
+	tests := []struct {
+		controller string
+		pathType   string
+		path       string
+		expected   []string
+		regex      bool
+	}{
+		{ControllerGCE, "", "/*", []string{"/*"}, false},
+		{ControllerGCE, "", "/api", []string{"/api"}, false},
+		{ControllerGCE, "", "", []string{""}, false},
+		{ControllerGCE, PathTypePrefix, "/", []string{"/*"}, false},
+		{ControllerGCE, PathTypePrefix, "/*", []string{"/*"}, false},
+		{ControllerGCE, PathTypePrefix, "/api", []string{"/api", "/api/*"}, false},
+		{ControllerGCE, PathTypePrefix, "/api/*", []string{"/api", "/api/*"}, false},
+		{ControllerGCE, PathTypeExact, "/api", []string{"/api"}, false},
+		{ControllerGCE, PathTypeImplementationSpecific, "/api/v1/*", []string{"/api/v1/*"}, false},
+		{ControllerNginx, "", "/*", []string{"/"}, false},
+		{ControllerNginx, "", "/api/*", []string{"/api"}, false},
+		{ControllerNginx, "", "/api", []string{"/api"}, false},
+		{ControllerNginx, "", "", []string{""}, false},
+		{ControllerNginx, PathTypePrefix, "/", []string{"/"}, false},
+		{ControllerNginx, PathTypePrefix, "/api/", []string{"/api"}, false},
+		{ControllerNginx, PathTypeExact, "/api.json", []string{`/api\.json$`}, true},
+		{ControllerNginx, PathTypeImplementationSpecific, "/api/v[0-9]+", []string{"/api/v[0-9]+"}, true},
+	}
+	for _, test := range tests {
+		paths, regex := translatePath(test.controller, test.pathType, test.path)
+		if !reflect.DeepEqual(test.expected, paths) || test.regex != regex {
+			t.Errorf("%s %s %q: Expected:\n%v %v\nGot:\n%v %v\n", test.controller, test.pathType, test.path, test.expected, test.regex, paths, regex)
+		}
+	}
+}
+
+func TestNewIngressListNginx(t *testing.T) {
+	nginx := Options{TargetController: ControllerNginx}
+	web := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /*\nbackend:\n  service: web\n  port: 80\n")
+	err, configs := BuildConfigs(corev1.ServiceList{Items: []corev1.Service{web}}, nil, nginx)
+	if err != nil {
+		t.Errorf("Error building ingress configs: %v\n", err)
+	}
+	ingress := NewIngressList(configs, nginx).Items[0]
+	if path := ingress.Spec.Rules[0].HTTP.Paths[0].Path; path != "/" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "/", path)
+	}
+	if value := ingress.ObjectMeta.Annotations[nginxUseRegexAnnotationKey]; value != "false" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "false", value)
+	}
+
+	// other paths are escaped once any are regular expressions
+	api := newAnnotatedService("api", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /api/v[0-9]+\npathType: ImplementationSpecific\nbackend:\n  service: api\n  port: 80\n")
+	docs := newAnnotatedService("docs", "apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /docs.v1\npathType: Prefix\nbackend:\n  service: docs\n  port: 80\n")
+	err, configs = BuildConfigs(corev1.ServiceList{Items: []corev1.Service{web, api, docs}}, nil, nginx)
+	if err != nil {
+		t.Errorf("Error building ingress configs: %v\n", err)
+	}
+	ingress = NewIngressList(configs, nginx).Items[0]
+	paths := []string{}
+	for _, path := range ingress.Spec.Rules[0].HTTP.Paths {
+		paths = append(paths, path.Path)
+	}
+	expected := []string{"/", "/api/v[0-9]+", `/docs\.v1`}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, paths)
+	}
+	if value := ingress.ObjectMeta.Annotations[nginxUseRegexAnnotationKey]; value != "true" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "true", value)
+	}
+}
+
+func TestValidatePathType(t *testing.T) {
+	tests := []struct {
+		pathType string
+		path     string
+		fields   []string
+	}{
+		{"", "/*", []string{}},
+		{PathTypePrefix, "/api/*", []string{}},
+		{PathTypeExact, "/api", []string{}},
+		{PathTypeImplementationSpecific, "/api/v[0-9]+", []string{}},
+		{"Glob", "/api", []string{"pathType"}},
+		{PathTypePrefix, "", []string{"path"}},
+		{PathTypePrefix, "/api/*/v1", []string{"path"}},
+		{PathTypeExact, "/api/*", []string{"path"}},
+		{PathTypeImplementationSpecific, "/api/v[0-9", []string{"path"}},
+	}
+	for _, test := range tests {
+		fields := []string{}
+		for _, problem := range validatePathType(test.pathType, test.path) {
+			fields = append(fields, problem.field)
+		}
+		if !reflect.DeepEqual(test.fields, fields) {
+			t.Errorf("%s %q: Expected:\n%v\nGot:\n%v\n", test.pathType, test.path, test.fields, fields)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package manifests
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// HostPolicyName is the `ConfigMap` holding the host ownership and namespace policy, in the controller's namespace
+const HostPolicyName = "ingress-controller-controller-host-policy"
+
+// hostPolicyKey is the key of the `ConfigMap` data holding the policy
+const hostPolicyKey = "policy.yaml"
+
+// HostPolicy says which `Service`s may route which hosts, and what the `Service`s in each namespace may use
+type HostPolicy struct {
+	Rules []HostRule `yaml:"rules"`
+	// FirstCome gives hosts no rule matches to the namespace of the oldest `Service` routing them.
+	// The controller only sees one namespace, so only the CLI, given several, tells namespaces apart
+	FirstCome bool `yaml:"firstCome"`
+	// NamespaceRules restrict the settings of the `Service`s in the namespaces they match
+	NamespaceRules []NamespaceRule `yaml:"namespaceRules"`
+}
+
+// HostRule allows the `Service`s in the namespaces, and matching the selector, to route the hosts it matches
+type HostRule struct {
+	// Host is a hostname, or a domain suffix starting with `.`, matching the domain and its subdomains,
+	// or `*.` matching only its subdomains
+	Host string `yaml:"host"`
+	// Namespaces allowed to route the hosts, any when empty
+	Namespaces []string `yaml:"namespaces"`
+	// ServiceSelector is a label selector the `Service`s routing the hosts must match, any when empty.
+	// It's what tells teams apart in the controller, which only sees the `Service`s of one namespace
+	ServiceSelector string `yaml:"serviceSelector"`
+}
+
+// NamespaceRule restricts what the `Service`s in the namespaces may use.
+// A setting a namespace has several rules for must be allowed by one of them
+type NamespaceRule struct {
+	// Namespaces the rule is for, all when empty
+	Namespaces []string `yaml:"namespaces"`
+	// TLSSecrets are the `Secret` names, or path.Match patterns such as `web-*`, the namespaces may serve hosts with, any when empty
+	TLSSecrets []string `yaml:"tlsSecrets"`
+	// IngressClasses are the `Ingress` classes, or patterns, the namespaces may set, any when empty
+	IngressClasses []string `yaml:"ingressClasses"`
+	// IngressAnnotations are the `Ingress` annotation keys, or patterns such as `nginx.ingress.kubernetes.io/*`, the namespaces may set, any when empty
+	IngressAnnotations []string `yaml:"ingressAnnotations"`
+}
+
+// Violation is a route of a `Service` routing a host the policy doesn't allow it to,
+// or, when SecretName, IngressClass or Annotation is set, using a TLS `Secret`, `Ingress` class or `Ingress` annotation
+// the policy doesn't allow its namespace to use
+type Violation struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	// Route is the index of the route, empty when the annotations have no indexes
+	Route        string `json:"route,omitempty"`
+	Ingress      string `json:"ingress"`
+	Host         string `json:"host,omitempty"`
+	SecretName   string `json:"secretName,omitempty"`
+	IngressClass string `json:"ingressClass,omitempty"`
+	Annotation   string `json:"annotation,omitempty"`
+	Message      string `json:"message"`
+}
+
+func (v Violation) Error() string {
+	if v.SecretName != "" {
+		return fmt.Sprintf("%s/%s: TLS Secret '%s' for Ingress '%s' %s", v.Namespace, v.Service, v.SecretName, v.Ingress, v.Message)
+	}
+	if v.IngressClass != "" {
+		return fmt.Sprintf("%s/%s: class '%s' for Ingress '%s' %s", v.Namespace, v.Service, v.IngressClass, v.Ingress, v.Message)
+	}
+	if v.Annotation != "" {
+		return fmt.Sprintf("%s/%s: annotation '%s' for Ingress '%s' %s", v.Namespace, v.Service, v.Annotation, v.Ingress, v.Message)
+	}
+	return fmt.Sprintf("%s/%s: host '%s' for Ingress '%s' %s", v.Namespace, v.Service, v.Host, v.Ingress, v.Message)
+}
+
+// ParseHostPolicy reads the policy from its `ConfigMap`
+func ParseHostPolicy(configMap corev1.ConfigMap) (error, HostPolicy) {
+	policy := HostPolicy{}
+	err := yaml.UnmarshalStrict([]byte(configMap.Data[hostPolicyKey]), &policy)
+	if err != nil {
+		return fmt.Errorf("%s: %v", hostPolicyKey, err), HostPolicy{}
+	}
+	for i, rule := range policy.Rules {
+		if rule.Host == "" {
+			return fmt.Errorf("%s: rules[%d].host is required", hostPolicyKey, i), HostPolicy{}
+		}
+		_, err = labels.Parse(rule.ServiceSelector)
+		if err != nil {
+			return fmt.Errorf("%s: rules[%d].serviceSelector: %v", hostPolicyKey, i, err), HostPolicy{}
+		}
+	}
+	for i, rule := range policy.NamespaceRules {
+		for field, patterns := range map[string][]string{"tlsSecrets": rule.TLSSecrets, "ingressClasses": rule.IngressClasses, "ingressAnnotations": rule.IngressAnnotations} {
+			for j, pattern := range patterns {
+				_, err = path.Match(pattern, "")
+				if err != nil {
+					return fmt.Errorf("%s: namespaceRules[%d].%s[%d]: %v", hostPolicyKey, i, field, j, err), HostPolicy{}
+				}
+			}
+		}
+	}
+
+	return nil, policy
+}
+
+// GetHostPolicy reads the policy from its `ConfigMap` in the cache, or returns an empty policy if there isn't one
+func GetHostPolicy(lister corelisters.ConfigMapNamespaceLister) (error, HostPolicy) {
+	configMap, err := lister.Get(HostPolicyName)
+	if errors.IsNotFound(err) {
+		return nil, HostPolicy{}
+	}
+	if err != nil {
+		return err, HostPolicy{}
+	}
+
+	return ParseHostPolicy(*configMap)
+}
+
+// matches reports whether the rule is for the host, and how specifically, exact hosts being the most specific
+func (rule HostRule) matches(host string) (int, bool) {
+	switch {
+	case rule.Host == host:
+		return len(rule.Host) + 1, true
+	case strings.HasPrefix(rule.Host, "."):
+		if host == strings.TrimPrefix(rule.Host, ".") || strings.HasSuffix(host, rule.Host) {
+			return len(rule.Host), true
+		}
+	case strings.HasPrefix(rule.Host, "*."):
+		if strings.HasSuffix(host, strings.TrimPrefix(rule.Host, "*")) {
+			return len(rule.Host), true
+		}
+	}
+
+	return 0, false
+}
+
+// allows reports why the rule doesn't allow the `Service` to route its hosts, if it doesn't
+func (rule HostRule) allows(service corev1.Service) (string, bool) {
+	if len(rule.Namespaces) > 0 && !containsString(rule.Namespaces, service.ObjectMeta.Namespace) {
+		return fmt.Sprintf("isn't allowed for namespace '%s' by the host policy rule for '%s'", service.ObjectMeta.Namespace, rule.Host), false
+	}
+	// parsed when the policy was read
+	selector, _ := labels.Parse(rule.ServiceSelector)
+	if !selector.Matches(labels.Set(service.ObjectMeta.Labels)) {
+		return fmt.Sprintf("isn't allowed for Services not matching '%s' by the host policy rule for '%s'", rule.ServiceSelector, rule.Host), false
+	}
+
+	return "", true
+}
+
+// rule finds the most specific rule for the host
+func (policy HostPolicy) rule(host string) (HostRule, bool) {
+	best, found, specificity := HostRule{}, false, 0
+	for _, rule := range policy.Rules {
+		if s, ok := rule.matches(host); ok && s > specificity {
+			best, found, specificity = rule, true, s
+		}
+	}
+
+	return best, found
+}
+
+// allowsSetting reports why the policy doesn't allow the namespace to use the value of a setting, if it doesn't,
+// the setting being the patterns of each rule it's read from
+func (policy HostPolicy) allowsSetting(namespace, value string, setting func(NamespaceRule) []string) (string, bool) {
+	restricted := false
+	for _, rule := range policy.NamespaceRules {
+		patterns := setting(rule)
+		if len(patterns) == 0 || (len(rule.Namespaces) > 0 && !containsString(rule.Namespaces, namespace)) {
+			continue
+		}
+		restricted = true
+		for _, pattern := range patterns {
+			// patterns were checked when the policy was read
+			if matched, _ := path.Match(pattern, value); matched {
+				return "", true
+			}
+		}
+	}
+	if restricted {
+		return fmt.Sprintf("isn't allowed for namespace '%s' by the namespace policy rules", namespace), false
+	}
+
+	return "", true
+}
+
+func tlsSecrets(rule NamespaceRule) []string {
+	return rule.TLSSecrets
+}
+
+func ingressClasses(rule NamespaceRule) []string {
+	return rule.IngressClasses
+}
+
+func ingressAnnotations(rule NamespaceRule) []string {
+	return rule.IngressAnnotations
+}
+
+// CheckHostPolicy finds the routes of `Service`s routing hosts, or using TLS `Secret`s, `Ingress` classes or `Ingress` annotations,
+// the policy doesn't allow them to.
+// With FirstCome, hosts no rule matches belong to the namespace of the oldest `Service` routing them,
+// older being by creation time then namespace and name.
+// expects all services passed to be annotated and valid
+func CheckHostPolicy(sl corev1.ServiceList, policy HostPolicy, o Options) []Violation {
+	violations := []Violation{}
+	claims := map[string]string{}
+	for _, service := range sortByAge(sl.Items) {
+		err, routes := readRoutes(service, false, o)
+		if err != nil {
+			continue
+		}
+		for _, yc := range routes {
+			setting := Violation{
+				Namespace: service.ObjectMeta.Namespace,
+				Service:   service.ObjectMeta.Name,
+				Route:     yc.Index,
+				Ingress:   yc.ingressName(),
+			}
+			if yc.TLSSecret != "" {
+				if message, allowed := policy.allowsSetting(service.ObjectMeta.Namespace, yc.TLSSecret, tlsSecrets); !allowed {
+					violation := setting
+					violation.SecretName, violation.Message = yc.TLSSecret, message
+					violations = append(violations, violation)
+				}
+			}
+			if yc.IngressClass != "" {
+				if message, allowed := policy.allowsSetting(service.ObjectMeta.Namespace, yc.IngressClass, ingressClasses); !allowed {
+					violation := setting
+					violation.IngressClass, violation.Message = yc.IngressClass, message
+					violations = append(violations, violation)
+				}
+			}
+			for _, key := range sortedKeys(yc.IngressAnnotations) {
+				if message, allowed := policy.allowsSetting(service.ObjectMeta.Namespace, key, ingressAnnotations); !allowed {
+					violation := setting
+					violation.Annotation, violation.Message = key, message
+					violations = append(violations, violation)
+				}
+			}
+			for _, host := range yc.Hosts {
+				violation := Violation{
+					Namespace: service.ObjectMeta.Namespace,
+					Service:   service.ObjectMeta.Name,
+					Route:     yc.Index,
+					Ingress:   yc.ingressName(),
+					Host:      host,
+				}
+				if rule, ok := policy.rule(host); ok {
+					if message, allowed := rule.allows(service); !allowed {
+						violation.Message = message
+						violations = append(violations, violation)
+					}
+					continue
+				}
+				if !policy.FirstCome {
+					continue
+				}
+				claimed, ok := claims[host]
+				if !ok {
+					claims[host] = service.ObjectMeta.Namespace
+				} else if claimed != service.ObjectMeta.Namespace {
+					violation.Message = fmt.Sprintf("was claimed first by namespace '%s'", claimed)
+					violations = append(violations, violation)
+				}
+			}
+		}
+	}
+
+	return violations
+}
+
+// allowedRoutes leaves out the hosts of the `Service`'s routes that violate the policy, and the routes left with none,
+// and strips the TLS `Secret`s, `Ingress` classes and `Ingress` annotations that violate it from the routes
+func allowedRoutes(service corev1.Service, routes []yamlConfig, violations []Violation) []yamlConfig {
+	allowed := []yamlConfig{}
+	for _, yc := range routes {
+		rejectedHosts := []string{}
+		for _, violation := range violations {
+			if violation.Namespace != service.ObjectMeta.Namespace || violation.Service != service.ObjectMeta.Name || violation.Route != yc.Index {
+				continue
+			}
+			switch {
+			case violation.SecretName != "":
+				yc.TLSSecret = ""
+			case violation.IngressClass != "":
+				yc.IngressClass = ""
+			case violation.Annotation != "":
+				annotations := map[string]string{}
+				for key, value := range yc.IngressAnnotations {
+					if key != violation.Annotation {
+						annotations[key] = value
+					}
+				}
+				yc.IngressAnnotations = annotations
+			default:
+				rejectedHosts = append(rejectedHosts, violation.Host)
+			}
+		}
+		if len(rejectedHosts) > 0 {
+			hosts := []string{}
+			for _, host := range yc.Hosts {
+				if !containsString(rejectedHosts, host) {
+					hosts = append(hosts, host)
+				}
+			}
+			if len(hosts) == 0 {
+				continue
+			}
+			yc.Hosts = hosts
+		}
+		allowed = append(allowed, yc)
+	}
+
+	return allowed
+}
@@ -0,0 +1,204 @@
+package manifests
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newHostPolicy(policy string) corev1.ConfigMap {
+	return corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: HostPolicyName, Namespace: "default"},
+		Data:       map[string]string{hostPolicyKey: policy},
+	}
+}
+
+func TestParseHostPolicy(t *testing.T) {
+	err, policy := ParseHostPolicy(newHostPolicy("firstCome: true\nrules:\n- host: .example.com\n  namespaces: [web]\n  serviceSelector: team=web\n"))
+	if err != nil {
+		t.Errorf("Error parsing policy: %v", err)
+	}
+	expected := HostPolicy{
+		FirstCome: true,
+		Rules:     []HostRule{{Host: ".example.com", Namespaces: []string{"web"}, ServiceSelector: "team=web"}},
+	}
+	if !reflect.DeepEqual(expected, policy) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, policy)
+	}
+
+	for _, invalid := range []string{
+		"rules:\n- namespaces: [web]\n",
+		"rules:\n- host: example.com\n  serviceSelector: 'team in web'\n",
+		"rules:\n- host: example.com\n  namespace: web\n",
+		"namespaceRules:\n- tlsSecrets: ['web-[']\n",
+		"namespaceRules:\n- ingressAnnotations: ['[']\n",
+	} {
+		if err, _ := ParseHostPolicy(newHostPolicy(invalid)); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestCheckHostPolicy(t *testing.T) {
+	policy := HostPolicy{Rules: []HostRule{
+		{Host: ".example.com", Namespaces: []string{"web"}},
+		{Host: "*.api.example.com", Namespaces: []string{"api"}, ServiceSelector: "team=api"},
+		{Host: "status.example.com"},
+	}}
+	newService := func(namespace, name, host string, labels map[string]string) corev1.Service {
+		service := newAnnotatedService(name, "apiVersion: v1beta1\ningress: prod\nhost: "+host+"\nbackend:\n  service: "+name+"\n  port: 80\n")
+		service.ObjectMeta.Namespace = namespace
+		service.ObjectMeta.Labels = labels
+		return service
+	}
+	sl := corev1.ServiceList{Items: []corev1.Service{
+		newService("web", "web", "example.com", nil),
+		newService("web", "www", "www.example.com", nil),
+		newService("api", "hijack", "www.example.com", nil),
+		newService("api", "v1", "v1.api.example.com", map[string]string{"team": "api"}),
+		newService("api", "unlabeled", "v2.api.example.com", nil),
+		newService("other", "status", "status.example.com", nil),
+		newService("other", "unruled", "example.net", nil),
+	}}
+
+	result := CheckHostPolicy(sl, policy, Options{})
+	expected := []Violation{
+		{Namespace: "api", Service: "hijack", Ingress: "prod", Host: "www.example.com", Message: "isn't allowed for namespace 'api' by the host policy rule for '.example.com'"},
+		{Namespace: "api", Service: "unlabeled", Ingress: "prod", Host: "v2.api.example.com", Message: "isn't allowed for Services not matching 'team=api' by the host policy rule for '*.api.example.com'"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// the oldest namespace routing a host no rule matches gets it
+	policy.FirstCome = true
+	newer := newService("api", "newer", "example.net", nil)
+	newer.CreationTimestamp = metav1.NewTime(time.Unix(200, 0))
+	older := newService("web", "older", "example.net", nil)
+	older.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+	sameNamespace := newService("web", "same", "example.net", nil)
+	sl = corev1.ServiceList{Items: []corev1.Service{newer, older, sameNamespace}}
+	result = CheckHostPolicy(sl, policy, Options{})
+	expected = []Violation{
+		{Namespace: "api", Service: "newer", Ingress: "prod", Host: "example.net", Message: "was claimed first by namespace 'web'"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestCheckHostPolicyTLSSecrets(t *testing.T) {
+	policy := HostPolicy{NamespaceRules: []NamespaceRule{
+		{Namespaces: []string{"web"}, TLSSecrets: []string{"web-*"}},
+		{Namespaces: []string{"web", "api"}, TLSSecrets: []string{"shared-tls"}},
+		{Namespaces: []string{"other"}},
+	}}
+	newService := func(namespace, name, secret string) corev1.Service {
+		service := newAnnotatedService(name, "apiVersion: v1beta1\ningress: prod\nhost: "+name+".example.com\ntls:\n  secretName: "+secret+"\nbackend:\n  service: "+name+"\n  port: 80\n")
+		service.ObjectMeta.Namespace = namespace
+		return service
+	}
+	sl := corev1.ServiceList{Items: []corev1.Service{
+		newService("web", "www", "web-tls"),
+		newService("web", "shared", "shared-tls"),
+		newService("api", "api", "shared-tls"),
+		newService("api", "stolen", "web-tls"),
+		newService("other", "other", "web-tls"),
+	}}
+
+	result := CheckHostPolicy(sl, policy, Options{})
+	expected := []Violation{
+		{Namespace: "api", Service: "stolen", Ingress: "prod", SecretName: "web-tls", Message: "isn't allowed for namespace 'api' by the namespace policy rules"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestBuildConfigsHostPolicy(t *testing.T) {
+	policy := HostPolicy{
+		Rules:          []HostRule{{Host: ".example.com", Namespaces: []string{"web"}}},
+		NamespaceRules: []NamespaceRule{{TLSSecrets: []string{"web-*"}}},
+	}
+	service := newFlatService("api", map[string]string{
+		"ingress-name": "prod",
+		"service":      "api",
+		"port":         "80",
+		"0.host":       "api.example.net,www.example.com",
+		"1.host":       "www.example.com",
+		"2.host":       "secure.example.net",
+		"2.tls-secret": "api-tls",
+		"3.host":       "other.example.net",
+		"3.path":       "/other",
+	})
+	service.ObjectMeta.Namespace = "api"
+	sl := corev1.ServiceList{Items: []corev1.Service{service}}
+	violations := CheckHostPolicy(sl, policy, Options{})
+	if len(violations) != 3 || violations[0].Route != "0" || violations[1].Route != "1" || violations[2].Route != "2" {
+		t.Fatalf("Expected a violation for each of the first three routes, got %v", violations)
+	}
+
+	// only the hosts breaking the policy are left out, and the TLS Secret is stripped
+	err, configs := BuildConfigs(sl, violations, Options{})
+	if err != nil {
+		t.Errorf("Error building ingress configs: %v\n", err)
+	}
+	hosts := []string{}
+	for _, hc := range configs[0].HostConfigs {
+		hosts = append(hosts, hc.Host)
+	}
+	expected := []string{"api.example.net", "secure.example.net", "other.example.net"}
+	if !reflect.DeepEqual(expected, hosts) || configs[0].TLSConfigs != nil {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, configs)
+	}
+}
+
+func TestCheckHostPolicyIngressSettings(t *testing.T) {
+	policy := HostPolicy{NamespaceRules: []NamespaceRule{
+		{Namespaces: []string{"web"}, IngressClasses: []string{"public"}, IngressAnnotations: []string{"nginx.ingress.kubernetes.io/*"}},
+		{Namespaces: []string{"api"}, IngressClasses: []string{"internal-*"}},
+	}}
+	newService := func(namespace, name, settings string) corev1.Service {
+		service := newAnnotatedService(name, "apiVersion: v1beta1\ningress: "+name+"\nhost: "+name+".example.com\n"+settings+"backend:\n  service: "+name+"\n  port: 80\n")
+		service.ObjectMeta.Namespace = namespace
+		return service
+	}
+	sl := corev1.ServiceList{Items: []corev1.Service{
+		newService("web", "www", "ingressClass: public\ningressAnnotations:\n  nginx.ingress.kubernetes.io/proxy-body-size: 8m\n  example.com/team: web\n"),
+		newService("api", "api", "ingressClass: public\ningressAnnotations:\n  example.com/team: api\n"),
+		newService("api", "internal", "ingressClass: internal-east\n"),
+	}}
+
+	result := CheckHostPolicy(sl, policy, Options{})
+	expected := []Violation{
+		{Namespace: "api", Service: "api", Ingress: "api", IngressClass: "public", Message: "isn't allowed for namespace 'api' by the namespace policy rules"},
+		{Namespace: "web", Service: "www", Ingress: "www", Annotation: "example.com/team", Message: "isn't allowed for namespace 'web' by the namespace policy rules"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// only the settings breaking the policy are stripped
+	err, configs := BuildConfigs(sl, result, Options{})
+	if err != nil {
+		t.Errorf("Error building ingress configs: %v\n", err)
+	}
+	annotations := map[string]map[string]string{}
+	classes := map[string]string{}
+	for _, ic := range configs {
+		annotations[ic.Name] = ic.Annotations
+		classes[ic.Name] = ic.Class
+	}
+	expectedAnnotations := map[string]map[string]string{
+		"www":      {"nginx.ingress.kubernetes.io/proxy-body-size": "8m"},
+		"api":      {"example.com/team": "api"},
+		"internal": nil,
+	}
+	expectedClasses := map[string]string{"www": "public", "api": "", "internal": "internal-east"}
+	if !reflect.DeepEqual(expectedAnnotations, annotations) || !reflect.DeepEqual(expectedClasses, classes) {
+		t.Errorf("Expected:\n%v\n%v\nGot:\n%v\n%v\n", expectedAnnotations, expectedClasses, annotations, classes)
+	}
+}
@@ -0,0 +1,137 @@
+package manifests
+
+import (
+	"fmt"
+	"regexp"
+
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// shardAnnotationKey is the annotation on shards naming the `Ingress` they were split from
+const shardAnnotationKey = "ingress-controller-controller.alpha.davidamick.com/shard-of"
+
+// ShardOf returns the name of the `Ingress` a shard was split from, or the `Ingress`'s own name when it isn't a shard
+func ShardOf(ingress v1beta1.Ingress) string {
+	if base := ingress.ObjectMeta.Annotations[shardAnnotationKey]; base != "" {
+		return base
+	}
+
+	return ingress.ObjectMeta.Name
+}
+
+// ShardIngresses splits each `Ingress` in the list with more rules than the limit, see ShardIngress
+func ShardIngresses(il v1beta1.IngressList, observed v1beta1.IngressList, o Options) v1beta1.IngressList {
+	ingressList := v1beta1.IngressList{TypeMeta: il.TypeMeta}
+	for _, ingress := range il.Items {
+		ingressList.Items = append(ingressList.Items, ShardIngress(ingress, observed, o).Items...)
+	}
+
+	return ingressList
+}
+
+// ShardIngress splits an `Ingress` with more rules than o.MaxRulesPerIngress into shards named `name-0`, `name-1` and so on.
+// Each host stays in the shard it's observed in while that shard has room, so hosts don't move on unrelated changes,
+// and the rest fill the lowest numbered shards with room, in order. The default backend goes in the first shard,
+// and each shard gets the TLS hosts of its own rules
+func ShardIngress(ingress v1beta1.Ingress, observed v1beta1.IngressList, o Options) v1beta1.IngressList {
+	maxRulesPerIngress := o.MaxRulesPerIngress
+	ingressList := v1beta1.IngressList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Ingress",
+			APIVersion: "extensions/v1beta1",
+		},
+	}
+	rules := ingress.Spec.Rules
+	if maxRulesPerIngress <= 0 || len(rules) <= maxRulesPerIngress {
+		ingressList.Items = append(ingressList.Items, ingress)
+		return ingressList
+	}
+
+	count := (len(rules) + maxRulesPerIngress - 1) / maxRulesPerIngress
+	names := map[string]int{}
+	for i := 0; i < count; i++ {
+		names[shardName(ingress.Name, i)] = i
+	}
+	observedShards := map[string]int{}
+	for _, shard := range observed.Items {
+		index, ok := names[shard.ObjectMeta.Name]
+		if !ok || ShardOf(shard) != ingress.Name {
+			continue
+		}
+		for _, rule := range shard.Spec.Rules {
+			observedShards[rule.Host] = index
+		}
+	}
+
+	shardRules := make([][]v1beta1.IngressRule, count)
+	unplaced := []v1beta1.IngressRule{}
+	for _, rule := range rules {
+		index, ok := observedShards[rule.Host]
+		if ok && len(shardRules[index]) < maxRulesPerIngress {
+			shardRules[index] = append(shardRules[index], rule)
+			continue
+		}
+		unplaced = append(unplaced, rule)
+	}
+	for _, rule := range unplaced {
+		for index := range shardRules {
+			if len(shardRules[index]) < maxRulesPerIngress {
+				shardRules[index] = append(shardRules[index], rule)
+				break
+			}
+		}
+	}
+
+	for index, rules := range shardRules {
+		shard := *ingress.DeepCopy()
+		shard.ObjectMeta.Name = shardName(ingress.Name, index)
+		shard.ObjectMeta.Annotations[shardAnnotationKey] = ingress.Name
+		shard.Spec.Rules = rules
+		if index > 0 {
+			shard.Spec.Backend = nil
+		}
+		shard.Spec.TLS = shardTLS(ingress.Spec.TLS, rules)
+		ingressList.Items = append(ingressList.Items, shard)
+	}
+
+	return ingressList
+}
+
+var shardNamePattern = regexp.MustCompile(`^(.+)-[0-9]+$`)
+
+func shardName(name string, index int) string {
+	return fmt.Sprintf("%s-%d", name, index)
+}
+
+// shardBase returns the name of the `Ingress` a name would be a shard of
+func shardBase(name string) (string, bool) {
+	match := shardNamePattern.FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+
+	return match[1], true
+}
+
+// shardTLS keeps the TLS hosts that have rules in the shard
+func shardTLS(tls []v1beta1.IngressTLS, rules []v1beta1.IngressRule) []v1beta1.IngressTLS {
+	hosts := []string{}
+	for _, rule := range rules {
+		hosts = append(hosts, rule.Host)
+	}
+	var shardTLS []v1beta1.IngressTLS
+	for _, entry := range tls {
+		entryHosts := []string{}
+		for _, host := range entry.Hosts {
+			if containsString(hosts, host) {
+				entryHosts = append(entryHosts, host)
+			}
+		}
+		if len(entryHosts) > 0 {
+			shardTLS = append(shardTLS, v1beta1.IngressTLS{Hosts: entryHosts, SecretName: entry.SecretName})
+		}
+	}
+
+	return shardTLS
+}
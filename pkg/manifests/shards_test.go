@@ -0,0 +1,79 @@
+package manifests
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/extensions/v1beta1"
+)
+
+func shardHosts(il v1beta1.IngressList) map[string][]string {
+	hosts := map[string][]string{}
+	for _, ingress := range il.Items {
+		hosts[ingress.Name] = []string{}
+		for _, rule := range ingress.Spec.Rules {
+			hosts[ingress.Name] = append(hosts[ingress.Name], rule.Host)
+		}
+	}
+
+	return hosts
+}
+
+func newShardedIngress(hosts ...string) v1beta1.Ingress {
+	rules := []v1beta1.IngressRule{}
+	for _, host := range hosts {
+		rules = append(rules, v1beta1.IngressRule{Host: host})
+	}
+	ingress := newIngress("prod", rules)
+	ingress.Spec.TLS = []v1beta1.IngressTLS{{Hosts: []string{"a.example.com", "d.example.com"}, SecretName: "example-tls"}}
+	ingress.Spec.Backend = &v1beta1.IngressBackend{ServiceName: "fallback"}
+
+	return ingress
+}
+
+func TestShardIngress(t *testing.T) {
+	ingress := newShardedIngress("a.example.com", "b.example.com", "c.example.com")
+	if result := ShardIngress(ingress, v1beta1.IngressList{}, Options{}); len(result.Items) != 1 || result.Items[0].Name != "prod" {
+		t.Errorf("Expected no shards without a limit, got %v", shardHosts(result))
+	}
+
+	o := Options{MaxRulesPerIngress: 2}
+	result := ShardIngress(ingress, v1beta1.IngressList{}, o)
+	expected := map[string][]string{
+		"prod-0": {"a.example.com", "b.example.com"},
+		"prod-1": {"c.example.com"},
+	}
+	if !reflect.DeepEqual(expected, shardHosts(result)) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, shardHosts(result))
+	}
+	if ShardOf(result.Items[1]) != "prod" || result.Items[0].Spec.Backend == nil || result.Items[1].Spec.Backend != nil {
+		t.Errorf("Expected shards of 'prod' with the default backend in the first, got %v", result.Items)
+	}
+	expectedTLS := []v1beta1.IngressTLS{{Hosts: []string{"a.example.com"}, SecretName: "example-tls"}}
+	if !reflect.DeepEqual(expectedTLS, result.Items[0].Spec.TLS) || result.Items[1].Spec.TLS != nil {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expectedTLS, result.Items[0].Spec.TLS)
+	}
+
+	// removing a host doesn't move the others, and new hosts fill the gaps
+	ingress = newShardedIngress("b.example.com", "c.example.com", "d.example.com")
+	result = ShardIngress(ingress, result, o)
+	expected = map[string][]string{
+		"prod-0": {"b.example.com", "d.example.com"},
+		"prod-1": {"c.example.com"},
+	}
+	if !reflect.DeepEqual(expected, shardHosts(result)) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, shardHosts(result))
+	}
+
+	// adding a host before the others doesn't move them either
+	ingress = newShardedIngress("a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com")
+	result = ShardIngress(ingress, result, o)
+	expected = map[string][]string{
+		"prod-0": {"b.example.com", "d.example.com"},
+		"prod-1": {"c.example.com", "a.example.com"},
+		"prod-2": {"e.example.com"},
+	}
+	if !reflect.DeepEqual(expected, shardHosts(result)) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, shardHosts(result))
+	}
+}
@@ -0,0 +1,81 @@
+package manifests
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TemplateValues are the controller's values for host and path templates
+type TemplateValues struct {
+	// Cluster is the name of the cluster, for {{.Cluster}}
+	Cluster string
+	// Env is the name of the environment, for {{.Env}}
+	Env string
+}
+
+// templateData is what host and path templates can refer to
+type templateData struct {
+	Namespace   string
+	ServiceName string
+	Cluster     string
+	Env         string
+	Labels      map[string]string
+}
+
+// renderTemplates resolves the templates in a route's host and path for the `Service` it's read from
+func renderTemplates(service corev1.Service, yc *yamlConfig, values TemplateValues) error {
+	data := templateData{
+		Namespace:   service.ObjectMeta.Namespace,
+		ServiceName: service.ObjectMeta.Name,
+		Cluster:     values.Cluster,
+		Env:         values.Env,
+		Labels:      service.ObjectMeta.Labels,
+	}
+	if data.Labels == nil {
+		data.Labels = map[string]string{}
+	}
+
+	hosts := []string{}
+	for _, host := range yc.Hosts {
+		err, rendered := renderTemplate(host, data)
+		if err != nil {
+			return annotationError{field: "host", message: err.Error()}
+		}
+		hosts = append(hosts, rendered)
+	}
+	err, path := renderTemplate(yc.Path, data)
+	if err != nil {
+		return annotationError{field: "path", message: err.Error()}
+	}
+	if yc.Hosts != nil {
+		yc.Hosts = hosts
+	}
+	yc.Path = path
+
+	return nil
+}
+
+func renderTemplate(text string, data templateData) (error, string) {
+	if !isTemplate(text) {
+		return nil, text
+	}
+	// a label that isn't set is an error, rather than an empty string in a hostname
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err, ""
+	}
+	out := &bytes.Buffer{}
+	err = tmpl.Execute(out, data)
+	if err != nil {
+		return err, ""
+	}
+
+	return nil, out.String()
+}
+
+func isTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
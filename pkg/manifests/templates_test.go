@@ -0,0 +1,46 @@
+package manifests
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	o := Options{TemplateValues: TemplateValues{Cluster: "east", Env: "staging"}}
+	service := newAnnotatedService("web", "name: prod\nhost: '{{.ServiceName}}.{{.Env}}.{{.Cluster}}.example.com'\npath: '/{{.Namespace}}/{{.Labels.team}}'\nservice: web\nport: 80\n")
+	service.ObjectMeta.Labels = map[string]string{"team": "payments"}
+	err, routes := readRoutes(service, true, o)
+	if err != nil {
+		t.Errorf("Error reading routes: %v", err)
+	}
+	expected := []yamlConfig{{Name: "prod", Hosts: []string{"web.staging.east.example.com"}, Path: "/default/payments", Service: "web", Port: 80, Version: ConfigV1alpha1}}
+	if !reflect.DeepEqual(expected, routes) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, routes)
+	}
+
+	// a label that isn't set
+	service.ObjectMeta.Labels = map[string]string{}
+	problems := ValidateService(service, o)
+	if len(problems) != 1 || problems[0].Field != "path" {
+		t.Errorf("Expected a path problem, got %v", problems)
+	}
+
+	// rendered hosts must still be DNS names
+	service = newAnnotatedService("web", "name: prod\nhost: '{{.Env}}.example.com'\nservice: web\nport: 80\n")
+	problems = ValidateService(service, Options{})
+	if len(problems) != 1 || problems[0].Field != "host" {
+		t.Errorf("Expected a host problem, got %v", problems)
+	}
+
+	// indexed routes report their index
+	service = newFlatService("web", map[string]string{
+		"ingress-name": "prod",
+		"service":      "web",
+		"port":         "80",
+		"0.host":       "{{.Nope}}.example.com",
+	})
+	problems = ValidateService(service, Options{})
+	if len(problems) != 1 || problems[0].Field != "0.host" {
+		t.Errorf("Expected a 0.host problem, got %v", problems)
+	}
+}
@@ -0,0 +1,221 @@
+package manifests
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Problem is one reason a `Service`'s config annotation is rejected
+type Problem struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Field     string `json:"field"`
+	Message   string `json:"message"`
+}
+
+func (p Problem) Error() string {
+	return fmt.Sprintf("%s/%s: %s: %s", p.Namespace, p.Service, p.Field, p.Message)
+}
+
+// ValidateService checks a `Service`'s config annotations, returning nothing when they're valid or absent
+func ValidateService(service corev1.Service, o Options) []Problem {
+	problems := []Problem{}
+	problem := func(field, message string) {
+		problems = append(problems, Problem{
+			Namespace: service.ObjectMeta.Namespace,
+			Service:   service.ObjectMeta.Name,
+			Field:     field,
+			Message:   message,
+		})
+	}
+
+	if !IsAnnotated(service) {
+		return problems
+	}
+	err, routes := readRoutes(service, true, o)
+	if err != nil {
+		if annotationErr, ok := err.(annotationError); ok {
+			problem(annotationErr.field, annotationErr.message)
+		} else {
+			problem("config", err.Error())
+		}
+		return problems
+	}
+
+	for _, yc := range routes {
+		// fields of indexed routes are reported with their index, such as `0.host`
+		field := func(name string) string {
+			if yc.Index == "" {
+				return configFieldName(yc.Version, name)
+			}
+			return yc.Index + "." + name
+		}
+		if yc.Name == "" {
+			problem(field("name"), "is required")
+		} else {
+			for _, message := range validation.IsDNS1123Subdomain(yc.Name) {
+				problem(field("name"), message)
+			}
+		}
+		for _, host := range yc.Hosts {
+			if strings.HasPrefix(host, "*.") {
+				for _, message := range validation.IsWildcardDNS1123Subdomain(host) {
+					problem(field("host"), message)
+				}
+				continue
+			}
+			for _, message := range validation.IsDNS1123Subdomain(host) {
+				problem(field("host"), message)
+			}
+		}
+		if yc.TLSSecret != "" {
+			for _, message := range validation.IsDNS1123Subdomain(yc.TLSSecret) {
+				problem(field("tlsSecret"), message)
+			}
+		}
+		if yc.Path != "" && yc.DefaultBackend {
+			problem(field("path"), "must be unset for a default backend, which matches every path")
+		} else if yc.Path != "" && !strings.HasPrefix(yc.Path, "/") {
+			problem(field("path"), "must begin with '/'")
+		} else {
+			for _, message := range validatePathType(yc.PathType, yc.Path) {
+				problem(field(message.field), message.message)
+			}
+		}
+		if yc.Service == "" {
+			problem(field("service"), "is required")
+		} else {
+			for _, message := range validation.IsDNS1035Label(yc.Service) {
+				problem(field("service"), message)
+			}
+		}
+		for _, message := range validation.IsValidPortNum(yc.Port) {
+			problem(field("port"), message)
+		}
+		if yc.Canary {
+			switch {
+			case o.controller() != ControllerNginx:
+				problem(field("canaryWeight"), "canaries are only supported with -target-controller=nginx")
+			case yc.DefaultBackend:
+				problem(field("canaryWeight"), "a canary can't be a default backend")
+			case yc.CanaryWeight < 0 || yc.CanaryWeight > 100:
+				problem(field("canaryWeight"), "must be between 0 and 100")
+			}
+		}
+		if yc.IngressClass != "" {
+			for _, message := range validation.IsDNS1123Subdomain(yc.IngressClass) {
+				problem(field("ingressClass"), message)
+			}
+		}
+		for _, key := range sortedKeys(yc.IngressAnnotations) {
+			for _, message := range validation.IsQualifiedName(key) {
+				problem(field("ingressAnnotations"), fmt.Sprintf("'%s': %s", key, message))
+			}
+			if message, reserved := reservedIngressAnnotation(key); reserved {
+				problem(field("ingressAnnotations"), fmt.Sprintf("'%s' %s", key, message))
+			}
+		}
+	}
+
+	return problems
+}
+
+// validatePathType checks a path against its type, for every controller it could be translated for
+func validatePathType(pathType, path string) []annotationError {
+	problems := []annotationError{}
+	if pathType == "" {
+		return problems
+	}
+	if !containsString(pathTypes, pathType) {
+		return append(problems, annotationError{field: "pathType", message: fmt.Sprintf("must be one of %s", strings.Join(pathTypes, ", "))})
+	}
+	if path == "" {
+		return append(problems, annotationError{field: "path", message: "is required with a pathType"})
+	}
+	switch pathType {
+	case PathTypeExact:
+		if strings.Contains(path, "*") {
+			problems = append(problems, annotationError{field: "path", message: "must not contain '*' for an Exact path"})
+		}
+	case PathTypePrefix:
+		if strings.Contains(strings.TrimSuffix(path, "/*"), "*") {
+			problems = append(problems, annotationError{field: "path", message: "may only contain '*' as a trailing '/*' for a Prefix path"})
+		}
+	case PathTypeImplementationSpecific:
+		// a regular expression for nginx
+		if _, err := regexp.Compile(path); err != nil {
+			problems = append(problems, annotationError{field: "path", message: fmt.Sprintf("must be a valid regular expression: %v", err)})
+		}
+	}
+
+	return problems
+}
+
+// ValidateBackend checks that the `Service`s and ports a `Service`'s routes go to are in the list,
+// for when the list holds every `Service` that could be routed to, such as a repo's manifests
+func ValidateBackend(service corev1.Service, sl corev1.ServiceList, o Options) []Problem {
+	problems := []Problem{}
+	err, routes := readRoutes(service, false, o)
+	if err != nil || !IsAnnotated(service) {
+		return problems
+	}
+
+	for _, yc := range routes {
+		if yc.Service == "" {
+			continue
+		}
+		prefix := ""
+		if yc.Index != "" {
+			prefix = yc.Index + "."
+		}
+		problem := Problem{
+			Namespace: service.ObjectMeta.Namespace,
+			Service:   service.ObjectMeta.Name,
+			Field:     prefix + "service",
+			Message:   fmt.Sprintf("Service '%s' not found", yc.Service),
+		}
+		for _, backend := range sl.Items {
+			if backend.ObjectMeta.Namespace != service.ObjectMeta.Namespace || backend.ObjectMeta.Name != yc.Service {
+				continue
+			}
+			problem.Field = prefix + "port"
+			problem.Message = fmt.Sprintf("Service '%s' has no port %d", yc.Service, yc.Port)
+			for _, port := range backend.Spec.Ports {
+				if int(port.Port) == yc.Port {
+					problem.Message = ""
+				}
+			}
+		}
+		if problem.Message != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	return problems
+}
+
+// ValidServices drops the `Service`s with invalid config annotations, returning the problems found
+func ValidServices(sl corev1.ServiceList, o Options) (corev1.ServiceList, []Problem) {
+	serviceList := corev1.ServiceList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+	}
+	problems := []Problem{}
+	for _, service := range sl.Items {
+		serviceProblems := ValidateService(service, o)
+		if len(serviceProblems) > 0 {
+			problems = append(problems, serviceProblems...)
+			continue
+		}
+		serviceList.Items = append(serviceList.Items, service)
+	}
+
+	return serviceList, problems
+}
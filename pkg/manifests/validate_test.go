@@ -0,0 +1,157 @@
+package manifests
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newAnnotatedService(name, config string, ports ...int32) corev1.Service {
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{ConfigAnnotationKey: config},
+		},
+	}
+	for _, port := range ports {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{Port: port})
+	}
+
+	return service
+}
+
+func TestValidateService(t *testing.T) {
+	valid := newAnnotatedService("web", "name: prod\nhost: web.example.com\npath: /*\nservice: web\nport: 80\n")
+	if problems := ValidateService(valid, Options{}); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+
+	unannotated := newAnnotatedService("web", "")
+	if problems := ValidateService(unannotated, Options{}); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+
+	invalid := newAnnotatedService("web", "name: Prod\nhost: web_example.com\npath: web\nport: 70000\n")
+	fields := []string{}
+	for _, problem := range ValidateService(invalid, Options{}) {
+		fields = append(fields, problem.Field)
+	}
+	expected := []string{"name", "host", "path", "service", "port"}
+	if !reflect.DeepEqual(expected, fields) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, fields)
+	}
+
+	unknown := newAnnotatedService("web", "name: prod\nservice: web\nport: 80\nhots: web.example.com\n")
+	problems := ValidateService(unknown, Options{})
+	if len(problems) != 1 || problems[0].Field != "config" {
+		t.Errorf("Expected an unknown field to be a config problem, got %v", problems)
+	}
+}
+
+func TestValidateServiceHosts(t *testing.T) {
+	valid := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\nhosts: [web.example.com, \"*.example.com\"]\ntls:\n  secretName: example-tls\nbackend:\n  service: web\n  port: 80\n")
+	if problems := ValidateService(valid, Options{}); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+
+	for _, host := range []string{"*", "web.*.example.com", "*example.com"} {
+		invalid := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\nhosts: [\""+host+"\"]\nbackend:\n  service: web\n  port: 80\n")
+		problems := ValidateService(invalid, Options{})
+		if len(problems) != 1 || problems[0].Field != "host" {
+			t.Errorf("Expected a host problem for %q, got %v", host, problems)
+		}
+	}
+}
+
+func TestValidateServiceDefaultBackend(t *testing.T) {
+	valid := newFlatService("web", map[string]string{"ingress-name": "prod", "service": "web", "port": "80", "default-backend": "true"})
+	if problems := ValidateService(valid, Options{}); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+
+	invalid := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\npath: /web\ndefaultBackend: true\nbackend:\n  service: web\n  port: 80\n")
+	problems := ValidateService(invalid, Options{})
+	if len(problems) != 1 || problems[0].Field != "path" {
+		t.Errorf("Expected a path problem, got %v", problems)
+	}
+	invalid = newFlatService("web", map[string]string{"ingress-name": "prod", "service": "web", "port": "80", "default-backend": "yes please"})
+	problems = ValidateService(invalid, Options{})
+	if len(problems) != 1 || problems[0].Field != "default-backend" {
+		t.Errorf("Expected a default-backend problem, got %v", problems)
+	}
+}
+
+func TestValidateServiceCanary(t *testing.T) {
+	canary := newFlatService("web", map[string]string{"ingress-name": "prod", "service": "web", "port": "80", "canary-weight": "20"})
+	problems := ValidateService(canary, Options{})
+	if len(problems) != 1 || problems[0].Field != "canaryWeight" {
+		t.Errorf("Expected a canaryWeight problem without nginx, got %v", problems)
+	}
+
+	nginx := Options{TargetController: ControllerNginx}
+	if problems := ValidateService(canary, nginx); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+	for _, config := range []string{
+		"apiVersion: v1beta1\ningress: prod\ncanary:\n  weight: 120\nbackend:\n  service: web\n  port: 80\n",
+		"apiVersion: v1beta1\ningress: prod\ndefaultBackend: true\ncanary:\n  weight: 20\nbackend:\n  service: web\n  port: 80\n",
+	} {
+		problems := ValidateService(newAnnotatedService("web", config), nginx)
+		if len(problems) != 1 || problems[0].Field != "canary.weight" {
+			t.Errorf("Expected a canary.weight problem for %q, got %v", config, problems)
+		}
+	}
+}
+
+func TestValidateServiceIngressSettings(t *testing.T) {
+	valid := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\ningressClass: public\ningressAnnotations:\n  nginx.ingress.kubernetes.io/proxy-body-size: 8m\nbackend:\n  service: web\n  port: 80\n")
+	if problems := ValidateService(valid, Options{}); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+	for _, settings := range []string{
+		"ingressClass: Public\n",
+		"ingressAnnotations:\n  'bad key': x\n",
+		"ingressAnnotations:\n  kubernetes.io/ingress.class: public\n",
+		"ingressAnnotations:\n  nginx.ingress.kubernetes.io/canary: 'true'\n",
+		"ingressAnnotations:\n  ingress-controller-controller.alpha.davidamick.com/pinned: 'true'\n",
+	} {
+		invalid := newAnnotatedService("web", "apiVersion: v1beta1\ningress: prod\n"+settings+"backend:\n  service: web\n  port: 80\n")
+		if problems := ValidateService(invalid, Options{}); len(problems) != 1 {
+			t.Errorf("Expected one problem for %q, got %v", settings, problems)
+		}
+	}
+}
+
+func TestValidateBackend(t *testing.T) {
+	web := newAnnotatedService("web", "name: prod\nservice: web\nport: 80\n", 80)
+	api := newAnnotatedService("api", "name: prod\nservice: api\nport: 8080\n", 80)
+	other := newAnnotatedService("other", "name: prod\nservice: missing\nport: 80\n")
+	sl := corev1.ServiceList{Items: []corev1.Service{web, api, other}}
+
+	if problems := ValidateBackend(web, sl, Options{}); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+	expected := []Problem{{Namespace: "default", Service: "api", Field: "port", Message: "Service 'api' has no port 8080"}}
+	if result := ValidateBackend(api, sl, Options{}); !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+	expected = []Problem{{Namespace: "default", Service: "other", Field: "service", Message: "Service 'missing' not found"}}
+	if result := ValidateBackend(other, sl, Options{}); !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestValidServices(t *testing.T) {
+	web := newAnnotatedService("web", "name: prod\nservice: web\nport: 80\n")
+	bad := newAnnotatedService("bad", "name: prod\nservice: bad\n")
+	valid, problems := ValidServices(corev1.ServiceList{Items: []corev1.Service{web, bad}}, Options{})
+	if len(valid.Items) != 1 || valid.Items[0].Name != "web" {
+		t.Errorf("Expected only 'web' to be valid, got %v", valid.Items)
+	}
+	if len(problems) != 1 || problems[0].Service != "bad" {
+		t.Errorf("Expected one problem with 'bad', got %v", problems)
+	}
+}
@@ -0,0 +1,223 @@
+package manifests
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ConfigV1alpha1 is the original flat config, assumed when there's no apiVersion
+	ConfigV1alpha1 = "v1alpha1"
+	// ConfigV1beta1 names the `Ingress` with `ingress`, and nests the backend
+	ConfigV1beta1 = "v1beta1"
+	// LatestConfigVersion is the version NormalizeConfig writes
+	LatestConfigVersion = ConfigV1beta1
+)
+
+// deprecatedConfigVersions are still read, but should be rewritten in the latest version
+var deprecatedConfigVersions = map[string]bool{
+	ConfigV1alpha1: true,
+}
+
+// configVersion is just enough of any version of the config to know which it is
+type configVersion struct {
+	APIVersion string `yaml:"apiVersion"`
+}
+
+type configV1alpha1 struct {
+	APIVersion string `yaml:"apiVersion,omitempty"`
+	Name       string `yaml:"name"`
+	Host       string `yaml:"host"`
+	Path       string `yaml:"path"`
+	Service    string `yaml:"service"`
+	Port       int    `yaml:"port"`
+}
+
+type configV1beta1 struct {
+	APIVersion string `yaml:"apiVersion"`
+	Ingress    string `yaml:"ingress"`
+	// Host is a shorthand for when there's only one of Hosts
+	Host  string   `yaml:"host"`
+	Hosts []string `yaml:"hosts"`
+	Path  string   `yaml:"path"`
+	// PathType is how Path is matched, as in a networking.k8s.io/v1 `Ingress`
+	PathType string           `yaml:"pathType"`
+	TLS      tlsBackendConfig `yaml:"tls"`
+	Backend  backendConfig    `yaml:"backend"`
+	// DefaultBackend makes the backend the default for the `Ingress`, or the catch-all for the hosts
+	DefaultBackend bool `yaml:"defaultBackend"`
+	// Canary makes the backend a canary for the same hosts and path in the `Ingress`
+	Canary *canaryConfig `yaml:"canary"`
+	// IngressClass and IngressAnnotations are set on the `Ingress`
+	IngressClass       string            `yaml:"ingressClass"`
+	IngressAnnotations map[string]string `yaml:"ingressAnnotations"`
+}
+
+type canaryConfig struct {
+	Weight int `yaml:"weight"`
+}
+
+type tlsBackendConfig struct {
+	SecretName string `yaml:"secretName"`
+}
+
+type backendConfig struct {
+	Service string `yaml:"service"`
+	Port    int    `yaml:"port"`
+}
+
+// v1beta1FieldNames maps yamlConfig's fields to their names in a v1beta1 config, for reporting problems
+var v1beta1FieldNames = map[string]string{
+	"name":         "ingress",
+	"tlsSecret":    "tls.secretName",
+	"canaryWeight": "canary.weight",
+	"service":      "backend.service",
+	"port":         "backend.port",
+}
+
+// parseConfig reads a config annotation of any version, in YAML or JSON, converting it to a yamlConfig.
+// strict rejects unknown fields
+func parseConfig(value string, strict bool) (error, yamlConfig) {
+	unmarshal := yaml.Unmarshal
+	if strict {
+		unmarshal = yaml.UnmarshalStrict
+	}
+	version := configVersion{}
+	err := yaml.Unmarshal([]byte(value), &version)
+	if err != nil {
+		return err, yamlConfig{}
+	}
+
+	switch version.APIVersion {
+	case "", ConfigV1alpha1:
+		config := configV1alpha1{}
+		err = unmarshal([]byte(value), &config)
+		if err != nil {
+			return err, yamlConfig{}
+		}
+		yc := yamlConfig{
+			Name:    config.Name,
+			Path:    config.Path,
+			Service: config.Service,
+			Port:    config.Port,
+			Version: ConfigV1alpha1,
+		}
+		if config.Host != "" {
+			yc.Hosts = []string{config.Host}
+		}
+		return nil, yc
+	case ConfigV1beta1:
+		config := configV1beta1{}
+		err = unmarshal([]byte(value), &config)
+		if err != nil {
+			return err, yamlConfig{}
+		}
+		yc := yamlConfig{
+			Name:               config.Ingress,
+			Hosts:              config.Hosts,
+			Path:               config.Path,
+			PathType:           config.PathType,
+			TLSSecret:          config.TLS.SecretName,
+			Service:            config.Backend.Service,
+			Port:               config.Backend.Port,
+			DefaultBackend:     config.DefaultBackend,
+			IngressClass:       config.IngressClass,
+			IngressAnnotations: config.IngressAnnotations,
+			Version:            ConfigV1beta1,
+		}
+		if config.Host != "" {
+			yc.Hosts = append([]string{config.Host}, config.Hosts...)
+		}
+		if config.Canary != nil {
+			yc.Canary = true
+			yc.CanaryWeight = config.Canary.Weight
+		}
+		return nil, yc
+	}
+
+	return fmt.Errorf("unknown apiVersion '%s', expected %s or %s", version.APIVersion, ConfigV1alpha1, ConfigV1beta1), yamlConfig{}
+}
+
+// marshalConfig writes a yamlConfig as the latest version, with sorted keys, leaving out what's unset
+func marshalConfig(yc yamlConfig) (error, string) {
+	backend := yaml.MapSlice{}
+	if yc.Port != 0 {
+		backend = append(backend, yaml.MapItem{Key: "port", Value: yc.Port})
+	}
+	if yc.Service != "" {
+		backend = append(backend, yaml.MapItem{Key: "service", Value: yc.Service})
+	}
+	config := yaml.MapSlice{{Key: "apiVersion", Value: LatestConfigVersion}}
+	if len(backend) > 0 {
+		config = append(config, yaml.MapItem{Key: "backend", Value: backend})
+	}
+	if yc.Canary {
+		config = append(config, yaml.MapItem{Key: "canary", Value: yaml.MapSlice{{Key: "weight", Value: yc.CanaryWeight}}})
+	}
+	if yc.DefaultBackend {
+		config = append(config, yaml.MapItem{Key: "defaultBackend", Value: true})
+	}
+	if len(yc.Hosts) == 1 {
+		config = append(config, yaml.MapItem{Key: "host", Value: yc.Hosts[0]})
+	}
+	if len(yc.Hosts) > 1 {
+		config = append(config, yaml.MapItem{Key: "hosts", Value: yc.Hosts})
+	}
+	if yc.Name != "" {
+		config = append(config, yaml.MapItem{Key: "ingress", Value: yc.Name})
+	}
+	if len(yc.IngressAnnotations) > 0 {
+		config = append(config, yaml.MapItem{Key: "ingressAnnotations", Value: yc.IngressAnnotations})
+	}
+	if yc.IngressClass != "" {
+		config = append(config, yaml.MapItem{Key: "ingressClass", Value: yc.IngressClass})
+	}
+	if yc.Path != "" {
+		config = append(config, yaml.MapItem{Key: "path", Value: yc.Path})
+	}
+	if yc.PathType != "" {
+		config = append(config, yaml.MapItem{Key: "pathType", Value: yc.PathType})
+	}
+	if yc.TLSSecret != "" {
+		config = append(config, yaml.MapItem{Key: "tls", Value: yaml.MapSlice{{Key: "secretName", Value: yc.TLSSecret}}})
+	}
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return err, ""
+	}
+
+	return nil, string(out)
+}
+
+// DeprecatedConfig returns a warning when the `Service`'s config annotation is in a deprecated version
+func DeprecatedConfig(service corev1.Service) (string, bool) {
+	value := service.ObjectMeta.Annotations[ConfigAnnotationKey]
+	if value == "" {
+		return "", false
+	}
+	version := configVersion{}
+	err := yaml.Unmarshal([]byte(value), &version)
+	if err != nil {
+		return "", false
+	}
+	if version.APIVersion == "" {
+		version.APIVersion = ConfigV1alpha1
+	}
+	if !deprecatedConfigVersions[version.APIVersion] {
+		return "", false
+	}
+
+	return fmt.Sprintf("Config annotation version %s is deprecated, and was converted to %s, please update it to apiVersion: %s", version.APIVersion, LatestConfigVersion, LatestConfigVersion), true
+}
+
+// configFieldName is what the yamlConfig field is called in the version of the config
+func configFieldName(version, field string) string {
+	if name, ok := v1beta1FieldNames[field]; ok && version == ConfigV1beta1 {
+		return name
+	}
+
+	return field
+}
@@ -0,0 +1,73 @@
+package manifests
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	expected := yamlConfig{Name: "prod", Hosts: []string{"web.example.com"}, Path: "/", Service: "web", Port: 80, Version: ConfigV1beta1}
+	for _, value := range []string{
+		"apiVersion: v1beta1\ningress: prod\nhost: web.example.com\npath: /\nbackend:\n  service: web\n  port: 80\n",
+		`{"apiVersion": "v1beta1", "ingress": "prod", "host": "web.example.com", "path": "/", "backend": {"service": "web", "port": 80}}`,
+	} {
+		err, result := parseConfig(value, true)
+		if err != nil {
+			t.Errorf("Error parsing config: %v", err)
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+		}
+	}
+
+	// older versions are converted
+	expected.Version = ConfigV1alpha1
+	for _, value := range []string{
+		"name: prod\nhost: web.example.com\npath: /\nservice: web\nport: 80\n",
+		"apiVersion: v1alpha1\nname: prod\nhost: web.example.com\npath: /\nservice: web\nport: 80\n",
+		`{"name": "prod", "host": "web.example.com", "path": "/", "service": "web", "port": 80}`,
+	} {
+		err, result := parseConfig(value, true)
+		if err != nil {
+			t.Errorf("Error parsing config: %v", err)
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+		}
+	}
+
+	err, _ := parseConfig("apiVersion: v2\ningress: prod\n", false)
+	if err == nil {
+		t.Errorf("Expected an error for an unknown apiVersion")
+	}
+	// the old fields aren't in the new version
+	err, _ = parseConfig("apiVersion: v1beta1\nname: prod\n", true)
+	if err == nil {
+		t.Errorf("Expected an error for a v1alpha1 field in a v1beta1 config")
+	}
+}
+
+func TestDeprecatedConfig(t *testing.T) {
+	for _, config := range []string{"name: prod\n", `{"apiVersion": "v1alpha1", "name": "prod"}`} {
+		if _, deprecated := DeprecatedConfig(newAnnotatedService("web", config)); !deprecated {
+			t.Errorf("Expected %q to be deprecated", config)
+		}
+	}
+	for _, config := range []string{"apiVersion: v1beta1\ningress: prod\n", ""} {
+		if warning, deprecated := DeprecatedConfig(newAnnotatedService("web", config)); deprecated {
+			t.Errorf("Expected %q not to be deprecated, got %s", config, warning)
+		}
+	}
+}
+
+func TestValidateServiceFieldNames(t *testing.T) {
+	service := newAnnotatedService("web", "apiVersion: v1beta1\nhost: web.example.com\nbackend:\n  service: web\n")
+	fields := []string{}
+	for _, problem := range ValidateService(service, Options{}) {
+		fields = append(fields, problem.Field)
+	}
+	expected := []string{"ingress", "backend.port"}
+	if !reflect.DeepEqual(expected, fields) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, fields)
+	}
+}
@@ -0,0 +1,140 @@
+package stub
+
+import (
+	"time"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	extensionslisters "k8s.io/client-go/listers/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Caches hold the informer backed listers that reconcile loops read from,
+// so that only resyncs go to the API server
+type Caches struct {
+	serviceFactory  informers.SharedInformerFactory
+	ingressFactory  informers.SharedInformerFactory
+	ownerFactory    informers.SharedInformerFactory
+	policyFactory   informers.SharedInformerFactory
+	serviceInformer cache.SharedIndexInformer
+	policyInformer  cache.SharedIndexInformer
+
+	// Namespace is the namespace watched, which the `Ingress`s and their owners are created in
+	Namespace string
+
+	Services  corelisters.ServiceNamespaceLister
+	Ingresses extensionslisters.IngressNamespaceLister
+	// ServicesByIngress indexes `Service`s with manifests.IngressNameIndex
+	ServicesByIngress cache.Indexer
+	// Owners are the `ConfigMap`s owning the `Ingress`s we create
+	Owners corelisters.ConfigMapNamespaceLister
+	// HostPolicies holds the host policy `ConfigMap`, manifests.HostPolicyName, if there is one
+	HostPolicies corelisters.ConfigMapNamespaceLister
+
+	synced []cache.InformerSynced
+}
+
+// NewCaches watches `Service`s matching the selector, all `Ingress`s, our owner `ConfigMap`s, and the host policy `ConfigMap`, in the namespace
+func NewCaches(client kubernetes.Interface, namespace, selector string, resyncPeriod time.Duration) *Caches {
+	serviceFactory := informers.NewFilteredSharedInformerFactory(client, resyncPeriod, namespace, func(options *metav1.ListOptions) {
+		options.LabelSelector = selector
+	})
+	ingressFactory := informers.NewFilteredSharedInformerFactory(client, resyncPeriod, namespace, nil)
+	ownerFactory := informers.NewFilteredSharedInformerFactory(client, resyncPeriod, namespace, func(options *metav1.ListOptions) {
+		options.LabelSelector = manifests.OwnerLabelKey + "=true"
+	})
+	policyFactory := informers.NewFilteredSharedInformerFactory(client, resyncPeriod, namespace, func(options *metav1.ListOptions) {
+		options.FieldSelector = "metadata.name=" + manifests.HostPolicyName
+	})
+
+	serviceInformer := serviceFactory.Core().V1().Services()
+	ingressInformer := ingressFactory.Extensions().V1beta1().Ingresses()
+	ownerInformer := ownerFactory.Core().V1().ConfigMaps()
+	policyInformer := policyFactory.Core().V1().ConfigMaps()
+	serviceInformer.Informer().AddIndexers(cache.Indexers{
+		manifests.IngressNameIndex: manifests.IngressNameIndexFunc,
+	})
+
+	return &Caches{
+		serviceFactory:    serviceFactory,
+		ingressFactory:    ingressFactory,
+		ownerFactory:      ownerFactory,
+		policyFactory:     policyFactory,
+		serviceInformer:   serviceInformer.Informer(),
+		Namespace:         namespace,
+		policyInformer:    policyInformer.Informer(),
+		Services:          serviceInformer.Lister().Services(namespace),
+		Ingresses:         ingressInformer.Lister().Ingresses(namespace),
+		ServicesByIngress: serviceInformer.Informer().GetIndexer(),
+		Owners:            ownerInformer.Lister().ConfigMaps(namespace),
+		HostPolicies:      policyInformer.Lister().ConfigMaps(namespace),
+		synced: []cache.InformerSynced{
+			serviceInformer.Informer().HasSynced,
+			ingressInformer.Informer().HasSynced,
+			ownerInformer.Informer().HasSynced,
+			policyInformer.Informer().HasSynced,
+		},
+	}
+}
+
+// Start runs the informers and blocks until their caches have synced
+func (caches *Caches) Start(stop <-chan struct{}) bool {
+	caches.serviceFactory.Start(stop)
+	caches.ingressFactory.Start(stop)
+	caches.ownerFactory.Start(stop)
+	caches.policyFactory.Start(stop)
+
+	logrus.Infof("Waiting for Service, Ingress, owner ConfigMap and host policy caches to sync")
+	return cache.WaitForCacheSync(stop, caches.synced...)
+}
+
+// OnServiceEvent calls handle with a copy of each `Service` the cache adds, updates, resyncs or deletes,
+// so that reconciles are driven by the same watch the listers read from
+func (caches *Caches) OnServiceEvent(handle func(service *corev1.Service, deleted bool)) {
+	if caches.serviceInformer == nil {
+		return
+	}
+	caches.serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			handle(manifests.FromCache(obj.(*corev1.Service)).(*corev1.Service), false)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			handle(manifests.FromCache(newObj.(*corev1.Service)).(*corev1.Service), false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			// the last state is all we get when the delete was missed while disconnected
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			service, ok := obj.(*corev1.Service)
+			if !ok {
+				logrus.Errorf("Failed to handle deletion of unexpected object %T", obj)
+				return
+			}
+			handle(manifests.FromCache(service).(*corev1.Service), true)
+		},
+	})
+}
+
+// OnHostPolicyChange calls changed whenever the host policy `ConfigMap` is created, updated or deleted
+func (caches *Caches) OnHostPolicyChange(changed func()) {
+	if caches.policyInformer == nil {
+		return
+	}
+	caches.policyInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { changed() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// resyncs send updates without changes
+			if oldObj.(*corev1.ConfigMap).ResourceVersion != newObj.(*corev1.ConfigMap).ResourceVersion {
+				changed()
+			}
+		},
+		DeleteFunc: func(obj interface{}) { changed() },
+	})
+}
@@ -2,6 +2,9 @@ package stub
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
 
@@ -9,74 +12,792 @@ import (
 	"github.com/operator-framework/operator-sdk/pkg/util/k8sutil"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
 )
 
-func NewHandler(m *Metrics) sdk.Handler {
+// Options tune how the Handler batches and retries reconcile loops
+type Options struct {
+	// DebounceWindow is how long to wait after an event for more events before reconciling
+	DebounceWindow time.Duration
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff after failed reconciles
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// QPS and Burst limit how often failed reconciles are retried overall
+	QPS   float64
+	Burst int
+	// ReconcileInterval is how often every Ingress is reconciled regardless of events, 0 only does so at startup
+	ReconcileInterval time.Duration
+	// OrphanGracePeriod is how long a managed Ingress must have had no Services contributing to it before it's deleted
+	OrphanGracePeriod time.Duration
+	// MaxOrphanDeletions holds all deletions while more Ingresses than this are orphaned at once, 0 disables it
+	MaxOrphanDeletions int
+	// DryRun makes no changes to the API, and logs a plan of the changes it would make instead
+	DryRun bool
+	// Recorder records Events on Services, such as deprecation warnings, nil only logs them
+	Recorder record.EventRecorder
+	// Build is how Ingresses are built from the annotations
+	Build manifests.Options
+}
+
+func NewHandler(m *Metrics, o Options, c *Caches) *Handler {
+	rateLimiter := workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(o.RetryBaseDelay, o.RetryMaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(o.QPS), o.Burst)},
+	)
+	var w writer = sdkWriter{}
+	if o.DryRun {
+		w = newDryRunWriter(m.pendingChanges)
+	}
 	return &Handler{
 		metrics: m,
+		options: o,
+		caches:  c,
+		writer:  w,
+		queue:   workqueue.NewNamedRateLimitingQueue(rateLimiter, "icc"),
+		orphans: newOrphans(o.OrphanGracePeriod, o.MaxOrphanDeletions),
+
+		queuedAt:      map[string]time.Time{},
+		contributions: map[string][]string{},
+		deleted:       map[types.UID]string{},
+		warned:        map[string]string{},
+		violations:    map[string]string{},
+
+		pendingFinalizers: map[string]map[string]bool{},
 	}
 }
 
 type Metrics struct {
-	operatorErrors prometheus.Counter
+	operatorErrors    prometheus.Counter
+	reconcileDuration *prometheus.HistogramVec
+	annotatedServices prometheus.Gauge
+	rejectedConfigs   prometheus.Counter
+	managedIngresses  prometheus.Gauge
+	ingressOperations *prometheus.CounterVec
+	lastReconcileTime prometheus.Gauge
+	queueDepth        prometheus.Gauge
+	queueWait         prometheus.Histogram
+	reconcileRetries  prometheus.Counter
+	deferredDeletions *prometheus.CounterVec
+	pendingChanges    *prometheus.GaugeVec
+	policyViolations  prometheus.Counter
 }
 
 type Handler struct {
 	// Metrics example
 	metrics *Metrics
+	options Options
+	caches  *Caches
+	writer  writer
+
+	// keyed by ingress name, so bursts of events for the same Ingress collapse into one reconcile loop
+	queue workqueue.RateLimitingInterface
+	// when each key was first queued since it was last picked up, for the wait time metric
+	queuedAt      map[string]time.Time
+	queuedAtMutex sync.Mutex
+
+	// the ingress names each Service contributed to when we last saw it, keyed by namespace/name
+	contributions      map[string][]string
+	contributionsMutex sync.Mutex
+
+	orphans *orphans
 
-	// Fill me TODO
+	// the ingress names still to be reconciled before each deleting Service's finalizer can be removed
+	pendingFinalizers      map[string]map[string]bool
+	pendingFinalizersMutex sync.Mutex
+
+	// deleted Services, which the caches may still hold, mapped to their namespace/name
+	deleted      map[types.UID]string
+	deletedMutex sync.Mutex
+
+	// the config annotation each Service was last warned about, keyed by namespace/name, so each is only warned about once
+	warned      map[string]string
+	warnedMutex sync.Mutex
+
+	// the host policy violations each Service was last warned about, keyed by namespace/name
+	violations      map[string]string
+	violationsMutex sync.Mutex
 }
 
 func (handler *Handler) Handle(ctx context.Context, event sdk.Event) error {
 	switch object := event.Object.(type) {
 	case *corev1.Service:
-		// TODO run all of this case at start up too
-		err, services := manifests.GetAllServices()
+		err, names := manifests.IngressNames(*object)
 		if err != nil {
-			logrus.Errorf("Error listing Services: %v", err)
-			return err
+			logrus.Errorf("Error reading config annotation for Service '%s': %v", object.Name, err)
+			handler.metrics.rejectedConfigs.Inc()
+		}
+		key := object.Namespace + "/" + object.Name
+		handler.warnDeprecated(key, object, event.Deleted)
+		// with our finalizer, deletion shows up as an update with a deletion timestamp first
+		deleting := event.Deleted || object.DeletionTimestamp != nil
+		affected := []string{}
+		if deleting {
+			handler.markDeleted(object.UID, key)
+			// the deleted Service contributes to nothing, but reconcile what it did in case we never tracked it
+			affected = append(affected, names...)
+			names = []string{}
+		}
+		affected = append(affected, handler.trackService(key, names)...)
+
+		switch {
+		case event.Deleted && object.DeletionTimestamp == nil && manifests.HasFinalizer(*object):
+			// it stopped matching the selector rather than being deleted, so we won't see it again
+			releaseFinalizer(handler, object)
+		case deleting && manifests.HasFinalizer(*object):
+			handler.awaitReconciles(key, affected)
+		case !deleting:
+			updateFinalizer(handler, object, len(names) > 0)
+		}
+
+		for _, name := range affected {
+			handler.enqueue(name)
 		}
+		logrus.Debugf("Handled event for Service '%s'", object.Name)
+	}
+
+	return nil
+}
+
+// warnDeprecated warns once about each deprecated config annotation, with an Event on the Service
+func (handler *Handler) warnDeprecated(key string, service *corev1.Service, deleted bool) {
+	handler.warnedMutex.Lock()
+	defer handler.warnedMutex.Unlock()
+
+	warning, deprecated := manifests.DeprecatedConfig(*service)
+	if deleted || !deprecated {
+		delete(handler.warned, key)
+		return
+	}
+	value := service.ObjectMeta.Annotations[manifests.ConfigAnnotationKey]
+	if handler.warned[key] == value {
+		return
+	}
+	handler.warned[key] = value
+
+	logrus.Warnf("Service '%s' : %s", key, warning)
+	if handler.options.Recorder != nil && !handler.options.DryRun {
+		handler.options.Recorder.Event(service, corev1.EventTypeWarning, "DeprecatedConfig", warning)
+	}
+}
 
-		annotatedServices := manifests.GetAnnotatedServices(services)
+// trackService records the ingress names a Service now contributes to,
+// and returns those it contributed to before or after the change
+func (handler *Handler) trackService(key string, names []string) []string {
+	handler.contributionsMutex.Lock()
+	defer handler.contributionsMutex.Unlock()
 
-		err, configs := manifests.BuildConfigs(annotatedServices)
+	affected := append([]string{}, names...)
+	for _, previous := range handler.contributions[key] {
+		found := false
+		for _, name := range names {
+			if name == previous {
+				found = true
+			}
+		}
+		if !found {
+			affected = append(affected, previous)
+		}
+	}
+	if len(names) == 0 {
+		delete(handler.contributions, key)
+	} else {
+		handler.contributions[key] = names
+	}
+
+	return affected
+}
+
+// awaitReconciles holds a deleting Service's finalizer until the named Ingresses have been reconciled without it
+func (handler *Handler) awaitReconciles(key string, names []string) {
+	if len(names) == 0 {
+		err := removeFinalizer(handler, key)
+		if err != nil {
+			logrus.Errorf("Error removing finalizer from Service '%s': %v", key, err)
+		}
+		return
+	}
+
+	handler.pendingFinalizersMutex.Lock()
+	defer handler.pendingFinalizersMutex.Unlock()
+	if handler.pendingFinalizers[key] == nil {
+		handler.pendingFinalizers[key] = map[string]bool{}
+	}
+	for _, name := range names {
+		handler.pendingFinalizers[key][name] = true
+	}
+}
+
+// reconciled removes the finalizers of deleting Services that were only waiting on the named Ingress
+func (handler *Handler) reconciled(name string) error {
+	ready := []string{}
+	handler.pendingFinalizersMutex.Lock()
+	for key, names := range handler.pendingFinalizers {
+		if names[name] {
+			delete(names, name)
+			if len(names) == 0 {
+				delete(handler.pendingFinalizers, key)
+				ready = append(ready, key)
+			}
+		}
+	}
+	handler.pendingFinalizersMutex.Unlock()
+
+	for _, key := range ready {
+		err := removeFinalizer(handler, key)
 		if err != nil {
-			logrus.Errorf("Error building ingress configs: %v\n", err)
+			logrus.Errorf("Error removing finalizer from Service '%s': %v", key, err)
+			handler.awaitReconciles(key, []string{name})
 			return err
 		}
+	}
+
+	return nil
+}
 
-		calculatedIngresses := manifests.NewIngressList(configs)
+// markDeleted remembers a deleted Service, so reconcile loops ignore it while the caches catch up
+func (handler *Handler) markDeleted(uid types.UID, key string) {
+	handler.deletedMutex.Lock()
+	defer handler.deletedMutex.Unlock()
+	handler.deleted[uid] = key
+}
+
+// deletedServices returns the UIDs of deleted Services, forgetting those the caches no longer hold
+func (handler *Handler) deletedServices() map[types.UID]bool {
+	handler.deletedMutex.Lock()
+	defer handler.deletedMutex.Unlock()
+
+	uids := map[types.UID]bool{}
+	for uid, key := range handler.deleted {
+		_, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			delete(handler.deleted, uid)
+			continue
+		}
+		service, err := handler.caches.Services.Get(name)
+		if err != nil || service.UID != uid {
+			delete(handler.deleted, uid)
+			continue
+		}
+		uids[uid] = true
+	}
+
+	return uids
+}
+
+func (handler *Handler) enqueue(name string) {
+	handler.markQueued(name)
+	handler.queue.AddAfter(name, handler.options.DebounceWindow)
+	logrus.Debugf("Queued reconcile loop for Ingress '%s'", name)
+}
+
+// Start syncs the caches, then processes queued reconcile loops until the context is done
+func (handler *Handler) Start(ctx context.Context) {
+	handler.caches.OnServiceEvent(func(service *corev1.Service, deleted bool) {
+		handler.Handle(ctx, sdk.Event{Object: service, Deleted: deleted})
+	})
+	handler.caches.OnHostPolicyChange(func() {
+		logrus.Infof("Host policy changed, reconciling every Ingress")
+		handler.enqueueAll()
+	})
+	if !handler.caches.Start(ctx.Done()) {
+		logrus.Errorf("Failed to sync caches before shutdown")
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		handler.queue.ShutDown()
+	}()
+	go wait.Until(handler.runWorker, time.Second, ctx.Done())
+
+	if handler.options.ReconcileInterval > 0 {
+		go wait.Until(handler.enqueueAll, handler.options.ReconcileInterval, ctx.Done())
+	} else {
+		handler.enqueueAll()
+	}
+}
+
+// enqueueAll queues a reconcile loop for every Ingress that's annotated on a Service or managed by us,
+// so that orphans are cleaned up even when no Service events arrive
+func (handler *Handler) enqueueAll() {
+	err, names, orphanCount := handler.countOrphans()
+	if err != nil {
+		return
+	}
+	if handler.orphans.tooMany(orphanCount) {
+		logrus.Errorf("Found %d orphaned Ingresses, more than the %d allowed, holding all deletions", orphanCount, handler.options.MaxOrphanDeletions)
+	}
+
+	logrus.Debugf("Queueing reconcile loops for all %d Ingresses", len(names))
+	for name := range names {
+		handler.enqueue(name)
+	}
+}
+
+// countOrphans counts the managed Ingresses, other than pinned ones, that no live Service names,
+// and returns the names of every Ingress that's annotated on a Service or managed by us
+func (handler *Handler) countOrphans() (error, map[string]bool, int) {
+	names := map[string]bool{}
+
+	err, services := manifests.GetAllServices(handler.caches.Services)
+	if err != nil {
+		logrus.Errorf("Error listing Services: %v", err)
+		return err, names, 0
+	}
+	services = manifests.ExcludeServices(manifests.GetAnnotatedServices(services), handler.deletedServices())
+	services = manifests.ExcludeDeletingServices(services)
+	for _, service := range services.Items {
+		_, serviceNames := manifests.IngressNames(service)
+		for _, name := range serviceNames {
+			names[name] = true
+		}
+	}
+
+	err, ingresses := manifests.GetAllIngresses(handler.caches.Ingresses)
+	if err != nil {
+		logrus.Errorf("Error listing Ingresses: %v", err)
+		return err, names, 0
+	}
+	annotatedIngresses := manifests.GetAnnotatedIngresses(ingresses)
+	count := 0
+	for _, ingress := range annotatedIngresses.Items {
+		// shards are reconciled with the `Ingress` they were split from
+		if !names[manifests.ShardOf(ingress)] && !manifests.IsPinnedIngress(ingress) {
+			count++
+		}
+	}
+	for _, ingress := range annotatedIngresses.Items {
+		names[manifests.ShardOf(ingress)] = true
+	}
+
+	return nil, names, count
+}
+
+func (handler *Handler) runWorker() {
+	for handler.processNextItem() {
+	}
+}
+
+func (handler *Handler) processNextItem() bool {
+	key, quit := handler.queue.Get()
+	if quit {
+		return false
+	}
+	defer handler.queue.Done(key)
+	name := key.(string)
+	handler.observeQueued(name)
+
+	start := time.Now()
+	err := handler.reconcile(name)
+	if err == nil {
+		err = handler.reconciled(name)
+	}
+	if err != nil {
+		handler.metrics.reconcileDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		handler.metrics.reconcileRetries.Inc()
+		logrus.Errorf("Reconcile loop for Ingress '%s' failed, retrying: %v", name, err)
+		handler.markQueued(name)
+		handler.queue.AddRateLimited(key)
+		return true
+	}
+	handler.metrics.reconcileDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+	handler.metrics.lastReconcileTime.Set(float64(time.Now().Unix()))
+	handler.queue.Forget(key)
+	logrus.Debugf("Handled reconcile loop for Ingress '%s'", name)
+
+	return true
+}
+
+func (handler *Handler) markQueued(name string) {
+	handler.queuedAtMutex.Lock()
+	defer handler.queuedAtMutex.Unlock()
+	if _, ok := handler.queuedAt[name]; !ok {
+		handler.queuedAt[name] = time.Now()
+	}
+	handler.metrics.queueDepth.Set(float64(handler.queue.Len()))
+}
+
+func (handler *Handler) observeQueued(name string) {
+	handler.queuedAtMutex.Lock()
+	defer handler.queuedAtMutex.Unlock()
+	if queuedAt, ok := handler.queuedAt[name]; ok {
+		handler.metrics.queueWait.Observe(time.Since(queuedAt).Seconds())
+		delete(handler.queuedAt, name)
+	}
+	handler.metrics.queueDepth.Set(float64(handler.queue.Len()))
+}
+
+// reconcile builds the named `Ingress` from the `Service`s contributing to it, split into shards when it's too big,
+// and applies it, deleting it, or the shards it no longer needs, when nothing contributes to them anymore
+func (handler *Handler) reconcile(name string) error {
+	err, ingresses := manifests.GetAllIngresses(handler.caches.Ingresses)
+	if err != nil {
+		logrus.Errorf("Error listing Ingresses: %v", err)
+		return err
+	}
+
+	annotatedIngresses := manifests.GetAnnotatedIngresses(ingresses)
+	// shards are reconciled with the `Ingress` they were split from
+	if observed, found := manifests.FindIngress(annotatedIngresses, name); found {
+		name = manifests.ShardOf(observed)
+	}
+
+	err, desired, found := handler.desiredIngress(name)
+	if err != nil {
+		return err
+	}
+	desiredIngresses := v1beta1.IngressList{}
+	if found {
+		desiredIngresses = manifests.ShardIngress(desired, annotatedIngresses, handler.options.Build)
+	}
+
+	for i := range desiredIngresses.Items {
+		ingress := &desiredIngresses.Items[i]
+		ingress.ObjectMeta.Namespace = handler.caches.Namespace
+		handler.orphans.forget(ingress.Name)
+		err = manifests.CheckLimits(*ingress)
+		if err != nil {
+			logrus.Errorf("Rejected Ingress '%s' : %v", ingress.Name, err)
+			handler.metrics.rejectedConfigs.Inc()
+			return err
+		}
+		err, owner := ensureOwner(handler, ingress.Name)
+		if err != nil {
+			return err
+		}
+		manifests.SetOwner(ingress, owner)
+		err = applyIngress(handler, ingress, annotatedIngresses)
+		if err != nil {
+			logrus.Errorf("Error applying Ingress: %v", err)
+			return err
+		}
+	}
 
-		err, ingresses := manifests.GetAllIngresses()
+	for _, observed := range annotatedIngresses.Items {
+		if manifests.ShardOf(observed) != name {
+			continue
+		}
+		if _, found := manifests.FindIngress(desiredIngresses, observed.Name); found {
+			continue
+		}
+		orphan := observed
+		err = deleteOrphan(handler, &orphan)
 		if err != nil {
-			logrus.Errorf("Error listing Ingresses: %v", err)
 			return err
 		}
+	}
 
-		annotatedIngresses := manifests.GetAnnotatedIngresses(ingresses)
+	handler.updateGauges(ingresses)
 
-		orphans := manifests.GetOrphanedIngresses(calculatedIngresses, annotatedIngresses)
-		for _, orphan := range orphans.Items {
-			err = sdk.Delete(&orphan)
-			if err != nil {
-				logrus.Errorf("Error deleting Ingresses: %v", err)
-				return err
-			}
+	return nil
+}
+
+// desiredIngress calculates the named `Ingress` from the `Service`s contributing to it,
+// found is false when nothing contributes to it
+func (handler *Handler) desiredIngress(name string) (error, v1beta1.Ingress, bool) {
+	err, services := manifests.GetServicesForIngress(handler.caches.ServicesByIngress, name)
+	if err != nil {
+		logrus.Errorf("Error listing Services: %v", err)
+		return err, v1beta1.Ingress{}, false
+	}
+
+	annotatedServices := handler.liveServices(services)
+	validServices, problems := manifests.ValidServices(annotatedServices, handler.options.Build)
+	for _, problem := range problems {
+		logrus.Errorf("Rejected config annotation for Ingress '%s' : %v", name, problem)
+	}
+	rejected := len(annotatedServices.Items) - len(validServices.Items)
+
+	// routes conflict across every Ingress, so look at every Service
+	err, allServices := manifests.GetAllServices(handler.caches.Services)
+	if err != nil {
+		return err, v1beta1.Ingress{}, false
+	}
+	allValidServices, _ := manifests.ValidServices(handler.liveServices(allServices), handler.options.Build)
+
+	// checked first, so that a route for a host the Service isn't allowed to route can't win a conflict for it,
+	// and only the hosts, routes and settings breaking the policy are left out
+	err, policy := handler.hostPolicy()
+	if err != nil {
+		return err, v1beta1.Ingress{}, false
+	}
+	violations := manifests.CheckHostPolicy(allValidServices, policy, handler.options.Build)
+	handler.warnViolations(allValidServices, violations)
+	for _, violation := range violations {
+		if violation.Ingress == name {
+			logrus.Errorf("Rejected config annotation for Ingress '%s' : %v", name, violation)
+			handler.metrics.policyViolations.Inc()
+			rejected++
 		}
+	}
+
+	conflicts := manifests.FindConflicts(allValidServices, violations, handler.options.Build)
+	for _, conflict := range conflicts {
+		if conflict.Ingress == name {
+			logrus.Errorf("Rejected config annotation for Ingress '%s' : %v", name, conflict)
+			rejected++
+		}
+	}
+	validServices = manifests.ExcludeConflicts(validServices, conflicts)
+	if rejected > 0 {
+		handler.metrics.rejectedConfigs.Add(float64(rejected))
+	}
+
+	err, configs := manifests.BuildConfigs(validServices, violations, handler.options.Build)
+	if err != nil {
+		logrus.Errorf("Error building ingress configs: %v\n", err)
+		handler.metrics.rejectedConfigs.Inc()
+		return err, v1beta1.Ingress{}, false
+	}
 
-		for _, ingress := range calculatedIngresses.Items {
-			err = applyObject(handler, &ingress)
+	calculatedIngresses := manifests.NewIngressList(configs, handler.options.Build)
+	desired, found := manifests.FindIngress(calculatedIngresses, name)
+
+	return nil, desired, found
+}
+
+// hostPolicy reads the host policy, which allows everything when there's no policy `ConfigMap`.
+// An invalid policy is an error, so that nothing changes until it's fixed
+func (handler *Handler) hostPolicy() (error, manifests.HostPolicy) {
+	err, policy := manifests.GetHostPolicy(handler.caches.HostPolicies)
+	if err != nil {
+		logrus.Errorf("Failed to read host policy ConfigMap '%s' : %v", manifests.HostPolicyName, err)
+		handler.metrics.operatorErrors.Inc()
+		return err, manifests.HostPolicy{}
+	}
+
+	return nil, policy
+}
+
+// warnViolations records an Event on each Service violating the host policy, once per change in its violations
+func (handler *Handler) warnViolations(sl corev1.ServiceList, violations []manifests.Violation) {
+	handler.violationsMutex.Lock()
+	defer handler.violationsMutex.Unlock()
+
+	messages := map[string]string{}
+	for _, violation := range violations {
+		key := violation.Namespace + "/" + violation.Service
+		if messages[key] != "" {
+			messages[key] += "; "
+		}
+		messages[key] += violation.Error()
+	}
+	for _, service := range sl.Items {
+		key := service.Namespace + "/" + service.Name
+		message := messages[key]
+		if message == "" || handler.violations[key] == message {
+			continue
+		}
+		logrus.Warnf("Service '%s' : %s", key, message)
+		if handler.options.Recorder != nil && !handler.options.DryRun {
+			handler.options.Recorder.Event(&service, corev1.EventTypeWarning, "HostPolicyViolation", message)
+		}
+	}
+	handler.violations = messages
+}
+
+// liveServices finds the annotated `Service`s that aren't deleted or being deleted
+func (handler *Handler) liveServices(sl corev1.ServiceList) corev1.ServiceList {
+	annotatedServices := manifests.GetAnnotatedServices(sl)
+	annotatedServices = manifests.ExcludeServices(annotatedServices, handler.deletedServices())
+
+	return manifests.ExcludeDeletingServices(annotatedServices)
+}
+
+// updateGauges sets the object count metrics from the caches
+func (handler *Handler) updateGauges(ingresses v1beta1.IngressList) {
+	err, services := manifests.GetAllServices(handler.caches.Services)
+	if err == nil {
+		handler.metrics.annotatedServices.Set(float64(len(manifests.GetAnnotatedServices(services).Items)))
+	}
+	handler.metrics.managedIngresses.Set(float64(len(manifests.GetAnnotatedIngresses(ingresses).Items)))
+}
+
+// deleteOrphan deletes a managed `Ingress` nothing contributes to anymore, unless it's pinned,
+// still within its grace period, or deletions are being held
+func deleteOrphan(handler *Handler, orphan *v1beta1.Ingress) error {
+	if manifests.IsPinnedIngress(*orphan) {
+		handler.metrics.deferredDeletions.WithLabelValues(orphan.Name, "pinned").Inc()
+		logrus.Infof("Not deleting pinned orphaned Ingress '%s'", orphan.Name)
+		return nil
+	}
+	wait := handler.orphans.wait(orphan.Name, time.Now())
+	if wait > 0 {
+		handler.metrics.deferredDeletions.WithLabelValues(orphan.Name, "grace-period").Inc()
+		logrus.Infof("Deferring deletion of orphaned Ingress '%s' for %v", orphan.Name, wait)
+		handler.markQueued(orphan.Name)
+		handler.queue.AddAfter(orphan.Name, wait)
+		return nil
+	}
+	// counted now rather than at the last sweep, since orphans can pile up between sweeps
+	err, _, count := handler.countOrphans()
+	if err != nil {
+		return err
+	}
+	if handler.orphans.tooMany(count) {
+		handler.metrics.deferredDeletions.WithLabelValues(orphan.Name, "max-deletions").Inc()
+		logrus.Infof("Deferring deletion of orphaned Ingress '%s' while %d Ingresses are orphaned, more than the %d allowed", orphan.Name, count, handler.options.MaxOrphanDeletions)
+		handler.markQueued(orphan.Name)
+		handler.queue.AddAfter(orphan.Name, heldDeletionDelay)
+		return nil
+	}
+
+	// deleting the owner has Kubernetes garbage collect the Ingress, Ingresses from before owners existed are deleted directly
+	owner, ownerErr := handler.caches.Owners.Get(manifests.OwnerName(orphan.Name))
+	if ownerErr == nil {
+		err = handler.writer.delete(cachedOwner(owner))
+	} else {
+		err = handler.writer.delete(orphan)
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		logrus.Errorf("Error deleting Ingresses: %v", err)
+		handler.metrics.operatorErrors.Inc()
+		return err
+	}
+	handler.orphans.forget(orphan.Name)
+	handler.metrics.ingressOperations.WithLabelValues(orphan.Name, "delete").Inc()
+	logrus.Debugf("Deleted Ingress '%s'", orphan.Name)
+
+	return nil
+}
+
+// ensureOwner returns the `ConfigMap` owning the named `Ingress`, creating it if needed
+func ensureOwner(handler *Handler, name string) (error, corev1.ConfigMap) {
+	owner, err := handler.caches.Owners.Get(manifests.OwnerName(name))
+	if err == nil {
+		return nil, *cachedOwner(owner)
+	}
+
+	newOwner := manifests.NewOwner(name)
+	newOwner.ObjectMeta.Namespace = handler.caches.Namespace
+	err = handler.writer.create(&newOwner)
+	if err != nil && errors.IsAlreadyExists(err) {
+		// the cache hasn't caught up yet, or it isn't ours, and mustn't become the Ingress's owner
+		err = handler.writer.get(&newOwner)
+		if err == nil && !manifests.IsOwner(newOwner) {
+			err = fmt.Errorf("ConfigMap '%s' already exists without the label '%s'", newOwner.Name, manifests.OwnerLabelKey)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("Failed to create owner ConfigMap for Ingress '%s' : %v", name, err)
+		handler.metrics.operatorErrors.Inc()
+		return err, corev1.ConfigMap{}
+	}
+	logrus.Debugf("Created owner ConfigMap '%s'", newOwner.Name)
+
+	return nil, newOwner
+}
+
+func cachedOwner(owner *corev1.ConfigMap) *corev1.ConfigMap {
+	return manifests.FromCache(owner).(*corev1.ConfigMap)
+}
+
+// updateFinalizer adds or removes our finalizer from an annotated `Service`, if needed
+func updateFinalizer(handler *Handler, service *corev1.Service, wanted bool) {
+	err := setFinalizer(handler, service.DeepCopy(), wanted, false)
+	if err != nil {
+		// the next event for the Service will try again
+		logrus.Errorf("Failed to update finalizers of Service '%s' : %v", service.Name, err)
+		handler.metrics.operatorErrors.Inc()
+	}
+}
+
+// releaseFinalizer removes our finalizer from a `Service` that stopped matching the selector.
+// What we were handed is its state from before it stopped matching, and no more events will come for it,
+// so the live `Service` is updated instead
+func releaseFinalizer(handler *Handler, service *corev1.Service) {
+	err := setFinalizer(handler, service.DeepCopy(), false, true)
+	if err != nil && !errors.IsNotFound(err) {
+		logrus.Errorf("Failed to remove finalizer from Service '%s' : %v", service.Name, err)
+		handler.metrics.operatorErrors.Inc()
+	}
+}
+
+// setFinalizer adds or removes our finalizer, if needed, retrying conflicts against the live `Service`,
+// which is read first when live is true
+func setFinalizer(handler *Handler, service *corev1.Service, wanted, live bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if live {
+			err := handler.writer.get(service)
 			if err != nil {
-				logrus.Errorf("Error applying Ingress: %v", err)
 				return err
 			}
 		}
+		live = true
+		if manifests.HasFinalizer(*service) == wanted {
+			return nil
+		}
+		updated := service.DeepCopy()
+		if wanted {
+			manifests.AddFinalizer(updated)
+		} else {
+			manifests.RemoveFinalizer(updated)
+		}
+
+		return handler.writer.update(updated, service)
+	})
+}
 
-		logrus.Debugf("Handled event loop for Service '%s'", object.Name)
+// removeFinalizer lets a deleting `Service` go, now its routes have been removed
+func removeFinalizer(handler *Handler, key string) error {
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	service, err := handler.caches.Services.Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
 	}
+	updated := service.DeepCopy()
+	updated.TypeMeta = metav1.TypeMeta{
+		Kind:       "Service",
+		APIVersion: "v1",
+	}
+	manifests.RemoveFinalizer(updated)
+	err = handler.writer.update(updated, service)
+	if err != nil && !errors.IsNotFound(err) {
+		handler.metrics.operatorErrors.Inc()
+		return err
+	}
+	logrus.Debugf("Removed finalizer from Service '%s'", key)
+
+	return nil
+}
+
+// applyIngress creates, updates or leaves alone the desired `Ingress`, based on what was observed
+func applyIngress(handler *Handler, ingress *v1beta1.Ingress, observed v1beta1.IngressList) error {
+	existing, found := manifests.FindIngress(observed, ingress.Name)
+	if !found {
+		return applyObject(handler, ingress)
+	}
+	if !manifests.IngressChanged(*ingress, existing) {
+		handler.writer.unchanged(ingress)
+		handler.metrics.ingressOperations.WithLabelValues(ingress.Name, "noop").Inc()
+		logrus.Debugf("Ingress '%s' is up to date", ingress.Name)
+		return nil
+	}
+
+	ingress.ResourceVersion = existing.ResourceVersion
+	err := handler.writer.update(ingress, &existing)
+	if err != nil {
+		logrus.Errorf("Failed to update Ingress '%s' : %v", ingress.Name, err)
+		handler.metrics.operatorErrors.Inc()
+		return err
+	}
+	handler.metrics.ingressOperations.WithLabelValues(ingress.Name, "update").Inc()
+	logrus.Debugf("Reconciled Ingress '%s'", ingress.Name)
 
 	return nil
 }
@@ -84,18 +805,22 @@ func (handler *Handler) Handle(ctx context.Context, event sdk.Event) error {
 func applyObject(handler *Handler, obj sdk.Object) error {
 	kind := obj.GetObjectKind().GroupVersionKind().Kind
 	name, _, err := k8sutil.GetNameAndNamespace(obj)
-	err = sdk.Create(obj)
+	err = handler.writer.create(obj)
 	switch {
 	case err != nil && errors.IsAlreadyExists(err):
-		err = sdk.Update(obj)
+		err = handler.writer.update(obj, nil)
 		if err != nil {
 			logrus.Errorf("Failed to update %s '%s' : %v", kind, name, err)
 			handler.metrics.operatorErrors.Inc()
+		} else {
+			handler.metrics.ingressOperations.WithLabelValues(name, "update").Inc()
 		}
 	case err != nil:
 		logrus.Errorf("Failed to apply %s '%s' : %v", kind, name, err)
 		handler.metrics.operatorErrors.Inc()
 		return err
+	default:
+		handler.metrics.ingressOperations.WithLabelValues(name, "create").Inc()
 	}
 	logrus.Debugf("Reconciled %s '%s'", kind, name)
 
@@ -105,7 +830,7 @@ func applyObject(handler *Handler, obj sdk.Object) error {
 func createObject(handler *Handler, obj sdk.Object) error {
 	kind := obj.GetObjectKind().GroupVersionKind().Kind
 	name, _, err := k8sutil.GetNameAndNamespace(obj)
-	err = sdk.Create(obj)
+	err = handler.writer.create(obj)
 	if err != nil && !errors.IsAlreadyExists(err) {
 		logrus.Errorf("Failed to apply %s '%s' : %v", kind, name, err)
 		handler.metrics.operatorErrors.Inc()
@@ -117,14 +842,87 @@ func createObject(handler *Handler, obj sdk.Object) error {
 }
 
 func RegisterOperatorMetrics() (*Metrics, error) {
-	operatorErrors := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "icc_operator_reconcile_errors_total",
-		Help: "Number of errors that occurred while reconciling Ingress manifests",
-	})
-	err := prometheus.Register(operatorErrors)
-	if err != nil {
-		return nil, err
+	metrics := newMetrics()
+	collectors := []prometheus.Collector{
+		metrics.operatorErrors,
+		metrics.reconcileDuration,
+		metrics.annotatedServices,
+		metrics.rejectedConfigs,
+		metrics.managedIngresses,
+		metrics.ingressOperations,
+		metrics.lastReconcileTime,
+		metrics.queueDepth,
+		metrics.queueWait,
+		metrics.reconcileRetries,
+		metrics.deferredDeletions,
+		metrics.pendingChanges,
+		metrics.policyViolations,
+	}
+	for _, collector := range collectors {
+		err := prometheus.Register(collector)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &Metrics{operatorErrors: operatorErrors}, nil
+	return metrics, nil
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		operatorErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "icc_operator_reconcile_errors_total",
+			Help: "Number of errors that occurred while reconciling Ingress manifests",
+		}),
+		reconcileDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "icc_operator_reconcile_duration_seconds",
+			Help:    "Time taken by each reconcile loop, by result",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"result"}),
+		annotatedServices: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "icc_operator_annotated_services",
+			Help: "Number of Services carrying the config annotation in the last reconcile loop",
+		}),
+		rejectedConfigs: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "icc_operator_rejected_configs_total",
+			Help: "Number of times a Service config annotation was rejected",
+		}),
+		managedIngresses: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "icc_operator_managed_ingresses",
+			Help: "Number of managed Ingresses observed in the cache in the last reconcile loop",
+		}),
+		ingressOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "icc_operator_ingress_operations_total",
+			Help: "Number of creates, updates, deletes and no-ops applied to Ingresses, by ingress name",
+		}, []string{"ingress", "operation"}),
+		lastReconcileTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "icc_operator_last_successful_reconcile_timestamp_seconds",
+			Help: "Unix time of the last reconcile loop that completed without errors",
+		}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "icc_operator_queue_depth",
+			Help: "Number of reconcile loops ready to be processed",
+		}),
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "icc_operator_queue_wait_seconds",
+			Help:    "Time between the first queued event and the start of its reconcile loop",
+			Buckets: prometheus.DefBuckets,
+		}),
+		reconcileRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "icc_operator_reconcile_retries_total",
+			Help: "Number of failed reconcile loops queued for a retry with backoff",
+		}),
+		deferredDeletions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "icc_operator_deferred_deletions_total",
+			Help: "Number of times deleting an orphaned Ingress was deferred, by ingress name and reason",
+		}, []string{"ingress", "reason"}),
+		pendingChanges: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "icc_operator_pending_changes",
+			Help: "Number of changes planned but not made in dry-run mode, by kind and operation",
+		}, []string{"kind", "operation"}),
+		policyViolations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "icc_operator_host_policy_violations_total",
+			Help: "Number of times part of a Service config annotation was left out for breaking the host policy",
+		}),
+	}
 }
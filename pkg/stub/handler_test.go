@@ -0,0 +1,619 @@
+package stub
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	extensionslisters "k8s.io/client-go/listers/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestHandleCollapsesBursts(t *testing.T) {
+	handler, _ := newTestHandler(Options{
+		// long enough for the burst to be handled on a loaded machine
+		DebounceWindow: 200 * time.Millisecond,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  time.Second,
+		QPS:            10,
+		Burst:          10,
+	}, nil)
+	defer handler.queue.ShutDown()
+
+	for i := 0; i < 50; i++ {
+		service := newService("web", "name: production\nhost: this.example.com\npath: /*\nservice: web\nport: 80")
+		err := handler.Handle(context.TODO(), sdk.Event{Object: service})
+		if err != nil {
+			t.Errorf("Error handling event: %v", err)
+		}
+	}
+	if handler.queue.Len() != 0 {
+		t.Errorf("Expected nothing ready before the debounce window, got %d", handler.queue.Len())
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if handler.queue.Len() != 1 {
+		t.Errorf("Expected 1 queued reconcile loop, got %d", handler.queue.Len())
+	}
+	key, _ := handler.queue.Get()
+	if key != "production" {
+		t.Errorf("Expected reconcile loop for 'production', got '%v'", key)
+	}
+}
+
+func TestTrackService(t *testing.T) {
+	handler, _ := newTestHandler(Options{}, nil)
+	defer handler.queue.ShutDown()
+
+	// new Service
+	result := handler.trackService("default/web", []string{"staging"})
+	expected := []string{"staging"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// unchanged Service
+	result = handler.trackService("default/web", []string{"staging"})
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// moved to another Ingress, both need reconciling
+	result = handler.trackService("default/web", []string{"production"})
+	expected = []string{"production", "staging"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// annotation removed
+	result = handler.trackService("default/web", []string{})
+	expected = []string{"production"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+
+	// never annotated
+	result = handler.trackService("default/other", []string{})
+	expected = []string{}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestHandleDeletedService(t *testing.T) {
+	web := newService("web", "name: staging\nhost: that.example.com\npath: /that\nservice: web\nport: 80")
+	web.UID = "web-uid"
+	api := newService("api", "name: staging\nhost: other.example.com\npath: /api\nservice: api\nport: 80")
+	api.UID = "api-uid"
+	prod := newService("prod", "name: production\nhost: this.example.com\npath: /*\nservice: web\nport: 80")
+	prod.UID = "prod-uid"
+	handler, _ := newTestHandler(Options{}, newTestCaches(web, api, prod))
+	defer handler.queue.ShutDown()
+	for _, service := range []*corev1.Service{web, api, prod} {
+		handler.Handle(context.TODO(), sdk.Event{Object: service})
+	}
+
+	// web was the only contributor to that.example.com, and is still in the cache
+	err := handler.Handle(context.TODO(), sdk.Event{Object: web, Deleted: true})
+	if err != nil {
+		t.Errorf("Error handling event: %v", err)
+	}
+	err, desired, found := handler.desiredIngress("staging")
+	if err != nil {
+		t.Errorf("Error calculating Ingress: %v", err)
+	}
+	if !found {
+		t.Errorf("Expected Ingress 'staging' to still be desired")
+	}
+	hosts := []string{}
+	for _, rule := range desired.Spec.Rules {
+		hosts = append(hosts, rule.Host)
+	}
+	expected := []string{"other.example.com"}
+	if !reflect.DeepEqual(expected, hosts) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, hosts)
+	}
+
+	// prod was the only contributor to the production Ingress
+	err = handler.Handle(context.TODO(), sdk.Event{Object: prod, Deleted: true})
+	if err != nil {
+		t.Errorf("Error handling event: %v", err)
+	}
+	err, _, found = handler.desiredIngress("production")
+	if err != nil {
+		t.Errorf("Error calculating Ingress: %v", err)
+	}
+	if found {
+		t.Errorf("Expected Ingress 'production' not to be desired")
+	}
+	handler.contributionsMutex.Lock()
+	if _, ok := handler.contributions["default/prod"]; ok {
+		t.Errorf("Expected deleted Service to be forgotten")
+	}
+	handler.contributionsMutex.Unlock()
+
+	// a Service recreated with the same name isn't ignored
+	recreated := newService("prod", "name: production\nhost: this.example.com\npath: /*\nservice: web\nport: 80")
+	recreated.UID = "prod-uid-2"
+	handler.caches.ServicesByIngress.Update(recreated)
+	err, _, found = handler.desiredIngress("production")
+	if err != nil {
+		t.Errorf("Error calculating Ingress: %v", err)
+	}
+	if !found {
+		t.Errorf("Expected Ingress 'production' to be desired again")
+	}
+}
+
+func TestEnqueueAll(t *testing.T) {
+	prod := newService("prod", "name: production\nhost: this.example.com\npath: /*\nservice: web\nport: 80")
+	handler, _ := newTestHandler(Options{}, newTestCaches(prod))
+	defer handler.queue.ShutDown()
+	handler.caches.Ingresses = newTestIngresses(
+		newIngress("staging", map[string]string{"ingress-controller-controller.alpha.davidamick.com/managed": "true"}),
+		newIngress("unmanaged", map[string]string{}),
+	)
+
+	handler.enqueueAll()
+	names := []string{}
+	for handler.queue.Len() > 0 {
+		key, _ := handler.queue.Get()
+		names = append(names, key.(string))
+		handler.queue.Done(key)
+	}
+	sort.Strings(names)
+	expected := []string{"production", "staging"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, names)
+	}
+
+	// no annotated Services, managed Ingresses still get reconciled so they can be deleted
+	handler.caches.Services = newTestCaches().Services
+	handler.enqueueAll()
+	names = []string{}
+	for handler.queue.Len() > 0 {
+		key, _ := handler.queue.Get()
+		names = append(names, key.(string))
+		handler.queue.Done(key)
+	}
+	expected = []string{"staging"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, names)
+	}
+}
+
+func TestFinalizerAwaitsReconciles(t *testing.T) {
+	web := newService("web", "name: staging\nhost: that.example.com\npath: /that\nservice: web\nport: 80")
+	web.UID = "web-uid"
+	handler, writer := newTestHandler(Options{}, newTestCaches(web))
+	defer handler.queue.ShutDown()
+	handler.Handle(context.TODO(), sdk.Event{Object: web})
+	expectedChanges := map[string]string{"web": "update"}
+	if result := writer.changes("Service"); !reflect.DeepEqual(expectedChanges, result) {
+		t.Errorf("Expected the finalizer to be added, got %v", result)
+	}
+
+	deleting := web.DeepCopy()
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	deleting.Finalizers = []string{"ingress-controller-controller.alpha.davidamick.com/routes"}
+	err := handler.Handle(context.TODO(), sdk.Event{Object: deleting})
+	if err != nil {
+		t.Errorf("Error handling event: %v", err)
+	}
+	expected := map[string]map[string]bool{"default/web": {"staging": true}}
+	if !reflect.DeepEqual(expected, handler.pendingFinalizers) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, handler.pendingFinalizers)
+	}
+
+	// the deleting Service no longer contributes
+	err, _, found := handler.desiredIngress("staging")
+	if err != nil {
+		t.Errorf("Error calculating Ingress: %v", err)
+	}
+	if found {
+		t.Errorf("Expected Ingress 'staging' not to be desired")
+	}
+
+	// reconciling an unrelated Ingress doesn't release it
+	err = handler.reconciled("production")
+	if err != nil {
+		t.Errorf("Error releasing finalizers: %v", err)
+	}
+	if len(handler.pendingFinalizers) != 1 {
+		t.Errorf("Expected finalizer to still be pending")
+	}
+
+	err = handler.reconciled("staging")
+	if err != nil {
+		t.Errorf("Error releasing finalizers: %v", err)
+	}
+	if len(handler.pendingFinalizers) != 0 {
+		t.Errorf("Expected no pending finalizers, got %v", handler.pendingFinalizers)
+	}
+	if count := writer.count("update", "Service", "web"); count != 2 {
+		t.Errorf("Expected the finalizer to be added and removed, got %d updates", count)
+	}
+}
+
+func TestReleaseFinalizer(t *testing.T) {
+	// its last state before the selector's label was removed
+	stale := newService("web", "name: staging\nhost: that.example.com\npath: /that\nservice: web\nport: 80")
+	stale.Labels = map[string]string{"icc-operator": "true"}
+	stale.Finalizers = []string{"ingress-controller-controller.alpha.davidamick.com/routes"}
+	live := stale.DeepCopy()
+	live.Labels = map[string]string{}
+	handler, writer := newTestHandler(Options{}, newTestCaches())
+	defer handler.queue.ShutDown()
+	writer.live["Service/web"] = live
+	writer.conflicts = 1
+
+	err := handler.Handle(context.TODO(), sdk.Event{Object: stale, Deleted: true})
+	if err != nil {
+		t.Errorf("Error handling event: %v", err)
+	}
+	released := writer.live["Service/web"].(*corev1.Service)
+	if manifests.HasFinalizer(*released) {
+		t.Errorf("Expected the finalizer to be removed after the conflict")
+	}
+	if len(released.Labels) != 0 {
+		t.Errorf("Expected the removed labels to stay removed, got %v", released.Labels)
+	}
+}
+
+func TestEnsureOwner(t *testing.T) {
+	handler, writer := newTestHandler(Options{}, newTestCaches())
+	defer handler.queue.ShutDown()
+
+	// ours, but not in the cache yet
+	owner := manifests.NewOwner("staging")
+	owner.UID = "owner-uid"
+	writer.live["ConfigMap/icc-staging"] = &owner
+	err, result := ensureOwner(handler, "staging")
+	if err != nil || result.UID != "owner-uid" {
+		t.Errorf("Expected the existing owner, got %v %v", err, result.UID)
+	}
+
+	// a user's ConfigMap with the name never owns the Ingress
+	users := manifests.NewOwner("production")
+	users.Labels = map[string]string{}
+	writer.live["ConfigMap/icc-production"] = &users
+	if err, _ := ensureOwner(handler, "production"); err == nil {
+		t.Errorf("Expected an error for a ConfigMap without the owner label")
+	}
+}
+
+func newTestIngresses(ingresses ...*v1beta1.Ingress) extensionslisters.IngressNamespaceLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, ingress := range ingresses {
+		indexer.Add(ingress)
+	}
+
+	return extensionslisters.NewIngressLister(indexer).Ingresses("default")
+}
+
+func newIngress(name string, annotations map[string]string) *v1beta1.Ingress {
+	return &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+	}
+}
+
+func newTestCaches(services ...*corev1.Service) *Caches {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		manifests.IngressNameIndex: manifests.IngressNameIndexFunc,
+	})
+	for _, service := range services {
+		indexer.Add(service)
+	}
+
+	return &Caches{
+		Namespace:         "default",
+		Services:          corelisters.NewServiceLister(indexer).Services("default"),
+		Ingresses:         newTestIngresses(),
+		ServicesByIngress: indexer,
+		Owners:            corelisters.NewConfigMapLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})).ConfigMaps("default"),
+		HostPolicies:      corelisters.NewConfigMapLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})).ConfigMaps("default"),
+	}
+}
+
+// recordingWriter records the changes a Handler makes rather than making them, so tests never reach the API
+type recordingWriter struct {
+	mutex    sync.Mutex
+	recorded []change
+	// the objects get reads and create finds already there, by kind/name, which updates replace
+	live map[string]sdk.Object
+	// how many updates fail with a conflict before they succeed
+	conflicts int
+}
+
+type change struct {
+	operation string
+	kind      string
+	name      string
+}
+
+// newTestHandler builds a Handler whose changes are recorded by the returned writer
+func newTestHandler(o Options, c *Caches) (*Handler, *recordingWriter) {
+	handler := NewHandler(newMetrics(), o, c)
+	writer := &recordingWriter{live: map[string]sdk.Object{}}
+	handler.writer = writer
+
+	return handler, writer
+}
+
+func (w *recordingWriter) get(obj sdk.Object) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	kind, name := kindAndName(obj)
+	live, ok := w.live[kind+"/"+name]
+	if !ok {
+		return errors.NewNotFound(schema.GroupResource{Resource: kind}, name)
+	}
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(live.DeepCopyObject()).Elem())
+
+	return nil
+}
+
+func (w *recordingWriter) create(obj sdk.Object) error {
+	kind, name := kindAndName(obj)
+	w.mutex.Lock()
+	_, exists := w.live[kind+"/"+name]
+	w.mutex.Unlock()
+	if exists {
+		return errors.NewAlreadyExists(schema.GroupResource{Resource: kind}, name)
+	}
+	w.record("create", obj)
+	return nil
+}
+
+func (w *recordingWriter) update(obj, observed sdk.Object) error {
+	kind, name := kindAndName(obj)
+	w.mutex.Lock()
+	if w.conflicts > 0 {
+		w.conflicts--
+		w.mutex.Unlock()
+		return errors.NewConflict(schema.GroupResource{Resource: kind}, name, fmt.Errorf("stale"))
+	}
+	if _, ok := w.live[kind+"/"+name]; ok {
+		w.live[kind+"/"+name] = obj.DeepCopyObject().(sdk.Object)
+	}
+	w.mutex.Unlock()
+	w.record("update", obj)
+	return nil
+}
+
+func (w *recordingWriter) delete(obj sdk.Object) error {
+	w.record("delete", obj)
+	return nil
+}
+
+func (w *recordingWriter) unchanged(obj sdk.Object) {}
+
+func (w *recordingWriter) record(operation string, obj sdk.Object) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	kind, name := kindAndName(obj)
+	w.recorded = append(w.recorded, change{operation: operation, kind: kind, name: name})
+}
+
+// changes returns the last operation on each object of the kind, by name
+func (w *recordingWriter) changes(kind string) map[string]string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	changes := map[string]string{}
+	for _, c := range w.recorded {
+		if c.kind == kind {
+			changes[c.name] = c.operation
+		}
+	}
+
+	return changes
+}
+
+// count returns how many times the operation was made on the named object of the kind
+func (w *recordingWriter) count(operation, kind, name string) int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	count := 0
+	for _, c := range w.recorded {
+		if c == (change{operation: operation, kind: kind, name: name}) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func newService(name, config string) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Annotations: map[string]string{
+				"ingress-controller-controller.alpha.davidamick.com/config": config,
+			},
+		},
+	}
+}
+
+func TestDesiredIngressRejectsConflicts(t *testing.T) {
+	web := newService("web", "name: staging\nhost: that.example.com\npath: /that\nservice: web\nport: 80")
+	web.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+	// newer, and in another Ingress, but routing the same host and path
+	copycat := newService("copycat", "name: production\nhost: that.example.com\npath: /that\nservice: copycat\nport: 80")
+	copycat.CreationTimestamp = metav1.NewTime(time.Unix(200, 0))
+	invalid := newService("invalid", "name: production\nhost: that.example.com\npath: /invalid\nservice: invalid\n")
+	handler, _ := newTestHandler(Options{}, newTestCaches(web, copycat, invalid))
+	defer handler.queue.ShutDown()
+
+	err, _, found := handler.desiredIngress("production")
+	if err != nil {
+		t.Errorf("Error calculating Ingress: %v", err)
+	}
+	if found {
+		t.Errorf("Expected Ingress 'production' not to be desired")
+	}
+	err, _, found = handler.desiredIngress("staging")
+	if err != nil {
+		t.Errorf("Error calculating Ingress: %v", err)
+	}
+	if !found {
+		t.Errorf("Expected Ingress 'staging' to be desired")
+	}
+}
+
+func TestWarnDeprecated(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	handler, _ := newTestHandler(Options{Recorder: recorder}, newTestCaches())
+	defer handler.queue.ShutDown()
+
+	old := newService("old", "name: staging\nhost: that.example.com\npath: /that\nservice: old\nport: 80")
+	current := newService("current", "apiVersion: v1beta1\ningress: staging\nhost: that.example.com\npath: /current\nbackend:\n  service: current\n  port: 80")
+	for i := 0; i < 2; i++ {
+		handler.warnDeprecated("default/old", old, false)
+		handler.warnDeprecated("default/current", current, false)
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected one Event, got %d", len(recorder.Events))
+	}
+	event := <-recorder.Events
+	if !strings.HasPrefix(event, "Warning DeprecatedConfig Config annotation version v1alpha1 is deprecated") {
+		t.Errorf("Unexpected Event: %s", event)
+	}
+
+	// warned again once it changes
+	changed := newService("old", "name: staging\nhost: that.example.com\npath: /changed\nservice: old\nport: 80")
+	handler.warnDeprecated("default/old", changed, false)
+	if len(recorder.Events) != 1 {
+		t.Errorf("Expected an Event for the changed annotation, got %d", len(recorder.Events))
+	}
+}
+
+func TestReconcileShards(t *testing.T) {
+	services := []*corev1.Service{}
+	for _, name := range []string{"a", "b", "c"} {
+		services = append(services, newService(name, "name: prod\nhost: "+name+".example.com\nservice: "+name+"\nport: 80"))
+	}
+	caches := newTestCaches(services...)
+	managed := "ingress-controller-controller.alpha.davidamick.com/managed"
+	caches.Ingresses = newTestIngresses(
+		// from before it was too big
+		newIngress("prod", map[string]string{managed: "true"}),
+		// from when it was bigger
+		newIngress("prod-2", map[string]string{managed: "true", "ingress-controller-controller.alpha.davidamick.com/shard-of": "prod"}),
+	)
+	handler, writer := newTestHandler(Options{Build: manifests.Options{MaxRulesPerIngress: 2}}, caches)
+	defer handler.queue.ShutDown()
+
+	// reconciling a shard reconciles the Ingress it was split from
+	err := handler.reconcile("prod-2")
+	if err != nil {
+		t.Errorf("Error reconciling: %v", err)
+	}
+	expected := map[string]string{"prod-0": "create", "prod-1": "create", "prod": "delete", "prod-2": "delete"}
+	result := writer.changes("Ingress")
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestDeleteOrphanHoldsDeletions(t *testing.T) {
+	managed := map[string]string{"ingress-controller-controller.alpha.davidamick.com/managed": "true"}
+	caches := newTestCaches()
+	caches.Ingresses = newTestIngresses(newIngress("a", managed), newIngress("b", managed), newIngress("c", managed))
+
+	// more orphans than allowed, even though no sweep has counted them
+	handler, writer := newTestHandler(Options{MaxOrphanDeletions: 2}, caches)
+	defer handler.queue.ShutDown()
+	err := deleteOrphan(handler, manifests.FromCache(newIngress("a", managed)).(*v1beta1.Ingress))
+	if err != nil {
+		t.Errorf("Error deleting orphan: %v", err)
+	}
+	if changes := writer.changes("Ingress"); len(changes) != 0 {
+		t.Errorf("Expected deletions to be held, got %v", changes)
+	}
+	// and looked at again later, even without periodic reconciles
+	if _, queued := handler.queuedAt["a"]; !queued {
+		t.Errorf("Expected the held orphan to be requeued")
+	}
+
+	handler, writer = newTestHandler(Options{MaxOrphanDeletions: 3}, caches)
+	defer handler.queue.ShutDown()
+	err = deleteOrphan(handler, manifests.FromCache(newIngress("a", managed)).(*v1beta1.Ingress))
+	if err != nil {
+		t.Errorf("Error deleting orphan: %v", err)
+	}
+	expected := map[string]string{"a": "delete"}
+	result := writer.changes("Ingress")
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, result)
+	}
+}
+
+func TestDesiredIngressRejectsHostPolicyViolations(t *testing.T) {
+	// older, so it would win the conflict if it were allowed the host
+	hijack := newService("hijack", "apiVersion: v1beta1\ningress: production\nhost: that.example.com\npath: /that\nbackend:\n  service: hijack\n  port: 80")
+	hijack.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+	web := newService("web", "apiVersion: v1beta1\ningress: staging\nhost: that.example.com\npath: /that\nbackend:\n  service: web\n  port: 80")
+	web.Labels = map[string]string{"team": "web"}
+	web.CreationTimestamp = metav1.NewTime(time.Unix(200, 0))
+	caches := newTestCaches(hijack, web)
+	policies := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	policies.Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: manifests.HostPolicyName, Namespace: "default"},
+		Data:       map[string]string{"policy.yaml": "rules:\n- host: .example.com\n  serviceSelector: team=web\n"},
+	})
+	caches.HostPolicies = corelisters.NewConfigMapLister(policies).ConfigMaps("default")
+	recorder := record.NewFakeRecorder(10)
+	handler, _ := newTestHandler(Options{Recorder: recorder}, caches)
+	defer handler.queue.ShutDown()
+
+	for i := 0; i < 2; i++ {
+		err, _, found := handler.desiredIngress("production")
+		if err != nil || found {
+			t.Errorf("Expected Ingress 'production' not to be desired, got %v", err)
+		}
+	}
+	err, _, found := handler.desiredIngress("staging")
+	if err != nil || !found {
+		t.Errorf("Expected Ingress 'staging' to be desired, got %v", err)
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected one Event, got %d", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning HostPolicyViolation default/hijack: host 'that.example.com'") {
+		t.Errorf("Unexpected Event: %s", event)
+	}
+
+	// an invalid policy changes nothing
+	policies.Update(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: manifests.HostPolicyName, Namespace: "default"},
+		Data:       map[string]string{"policy.yaml": "rules: nope"},
+	})
+	if err, _, _ := handler.desiredIngress("staging"); err == nil {
+		t.Errorf("Expected an error for an invalid host policy")
+	}
+}
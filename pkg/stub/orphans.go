@@ -0,0 +1,58 @@
+package stub
+
+import (
+	"sync"
+	"time"
+)
+
+// heldDeletionDelay is how long an orphan whose deletion is held waits before it's looked at again,
+// since nothing else requeues it when reconciles only run at startup
+const heldDeletionDelay = time.Minute
+
+// orphans tracks managed Ingresses that nothing contributes to anymore,
+// so that they're only deleted once they've stayed that way for the grace period, and never en masse
+type orphans struct {
+	gracePeriod  time.Duration
+	maxDeletions int
+
+	mutex sync.Mutex
+	// when each orphan was first seen
+	since map[string]time.Time
+}
+
+func newOrphans(gracePeriod time.Duration, maxDeletions int) *orphans {
+	return &orphans{
+		gracePeriod:  gracePeriod,
+		maxDeletions: maxDeletions,
+		since:        map[string]time.Time{},
+	}
+}
+
+// wait returns how much longer the named orphan has to wait before it can be deleted
+func (o *orphans) wait(name string, now time.Time) time.Duration {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	since, ok := o.since[name]
+	if !ok {
+		since = now
+		o.since[name] = since
+	}
+	remaining := o.gracePeriod - now.Sub(since)
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
+// forget stops tracking the named Ingress, because it was deleted or is desired again
+func (o *orphans) forget(name string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	delete(o.since, name)
+}
+
+// tooMany reports whether deletions are held because count, the orphans there are now, is more than maxDeletions
+func (o *orphans) tooMany(count int) bool {
+	return o.maxDeletions > 0 && count > o.maxDeletions
+}
@@ -0,0 +1,59 @@
+package stub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOrphansWait(t *testing.T) {
+	o := newOrphans(time.Minute, 0)
+	now := time.Now()
+
+	result := o.wait("staging", now)
+	if result != time.Minute {
+		t.Errorf("Expected to wait %v for a new orphan, got %v", time.Minute, result)
+	}
+
+	result = o.wait("staging", now.Add(45*time.Second))
+	if result != 15*time.Second {
+		t.Errorf("Expected to wait %v, got %v", 15*time.Second, result)
+	}
+
+	result = o.wait("staging", now.Add(2*time.Minute))
+	if result != 0 {
+		t.Errorf("Expected not to wait after the grace period, got %v", result)
+	}
+
+	// desired again, so the grace period starts over
+	o.forget("staging")
+	result = o.wait("staging", now.Add(3*time.Minute))
+	if result != time.Minute {
+		t.Errorf("Expected to wait %v for a forgotten orphan, got %v", time.Minute, result)
+	}
+
+	// no grace period
+	o = newOrphans(0, 0)
+	result = o.wait("staging", now)
+	if result != 0 {
+		t.Errorf("Expected not to wait without a grace period, got %v", result)
+	}
+}
+
+func TestOrphansTooMany(t *testing.T) {
+	o := newOrphans(0, 2)
+	if o.tooMany(2) {
+		t.Errorf("Expected deletions to be allowed at the threshold")
+	}
+	if !o.tooMany(3) {
+		t.Errorf("Expected deletions to be held above the threshold")
+	}
+	if o.tooMany(0) {
+		t.Errorf("Expected deletions to be allowed again")
+	}
+
+	// no threshold
+	o = newOrphans(0, 0)
+	if o.tooMany(100) {
+		t.Errorf("Expected deletions to be allowed without a threshold")
+	}
+}
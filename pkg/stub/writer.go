@@ -0,0 +1,142 @@
+package stub
+
+import (
+	"sync"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// writer makes every change the Handler makes to the API, and the reads those changes are based on
+type writer interface {
+	// get reads the live object, rather than the cached one, into obj
+	get(obj sdk.Object) error
+	create(obj sdk.Object) error
+	// observed is what the object looked like before the update, if known
+	update(obj, observed sdk.Object) error
+	delete(obj sdk.Object) error
+	// unchanged records that the object needed no changes
+	unchanged(obj sdk.Object)
+}
+
+type sdkWriter struct{}
+
+func (sdkWriter) get(obj sdk.Object) error {
+	return sdk.Get(obj)
+}
+
+func (sdkWriter) create(obj sdk.Object) error {
+	return sdk.Create(obj)
+}
+
+func (sdkWriter) update(obj, observed sdk.Object) error {
+	return sdk.Update(obj)
+}
+
+func (sdkWriter) delete(obj sdk.Object) error {
+	return sdk.Delete(obj)
+}
+
+func (sdkWriter) unchanged(obj sdk.Object) {}
+
+// dryRunWriter makes no changes, it logs a plan of what it would change,
+// and keeps the pending changes metric up to date
+type dryRunWriter struct {
+	pendingChanges *prometheus.GaugeVec
+
+	mutex sync.Mutex
+	// the planned operation for each kind/name
+	pending map[string]map[string]string
+}
+
+func newDryRunWriter(pendingChanges *prometheus.GaugeVec) *dryRunWriter {
+	return &dryRunWriter{
+		pendingChanges: pendingChanges,
+		pending:        map[string]map[string]string{},
+	}
+}
+
+// get reads from the API, since reading changes nothing
+func (w *dryRunWriter) get(obj sdk.Object) error {
+	return sdk.Get(obj)
+}
+
+func (w *dryRunWriter) create(obj sdk.Object) error {
+	kind, name := kindAndName(obj)
+	_, desired := manifests.ToYAML(obj)
+	w.plan(kind, name, "create", manifests.UnifiedDiff("/dev/null", kind+"/"+name, "", desired))
+	return nil
+}
+
+func (w *dryRunWriter) update(obj, observed sdk.Object) error {
+	kind, name := kindAndName(obj)
+	diff := ""
+	if observed != nil {
+		_, before := manifests.ToYAML(observed)
+		_, after := manifests.ToYAML(obj)
+		diff = manifests.UnifiedDiff(kind+"/"+name, kind+"/"+name, before, after)
+	}
+	w.plan(kind, name, "update", diff)
+	return nil
+}
+
+func (w *dryRunWriter) delete(obj sdk.Object) error {
+	kind, name := kindAndName(obj)
+	w.plan(kind, name, "delete", "")
+	// Kubernetes garbage collects the Ingress with its owner, and that's the deletion worth seeing
+	if owner, ok := obj.(*corev1.ConfigMap); ok && manifests.IsOwner(*owner) {
+		w.plan("Ingress", owner.Data["ingress"], "delete", "")
+	}
+	return nil
+}
+
+func (w *dryRunWriter) unchanged(obj sdk.Object) {
+	kind, name := kindAndName(obj)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	delete(w.pending[kind], name)
+	w.updateMetric(kind)
+}
+
+func (w *dryRunWriter) plan(kind, name, operation, diff string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.pending[kind] == nil {
+		w.pending[kind] = map[string]string{}
+	}
+	w.pending[kind][name] = operation
+	w.updateMetric(kind)
+
+	logrus.WithFields(logrus.Fields{
+		"dryRun":    true,
+		"operation": operation,
+		"kind":      kind,
+		"name":      name,
+		"diff":      diff,
+	}).Infof("Would %s %s '%s'", operation, kind, name)
+}
+
+func (w *dryRunWriter) updateMetric(kind string) {
+	counts := map[string]int{"create": 0, "update": 0, "delete": 0}
+	for _, operation := range w.pending[kind] {
+		counts[operation]++
+	}
+	for operation, count := range counts {
+		w.pendingChanges.WithLabelValues(kind, operation).Set(float64(count))
+	}
+}
+
+func kindAndName(obj sdk.Object) (string, string) {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return kind, ""
+	}
+
+	return kind, accessor.GetName()
+}
@@ -0,0 +1,55 @@
+package stub
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDryRunWriter(t *testing.T) {
+	w := newDryRunWriter(newMetrics().pendingChanges)
+	web := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{Kind: "Service", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+	}
+	api := web.DeepCopy()
+	api.Name = "api"
+
+	err := w.create(web)
+	if err != nil {
+		t.Errorf("Error planning create: %v", err)
+	}
+	err = w.update(api, web)
+	if err != nil {
+		t.Errorf("Error planning update: %v", err)
+	}
+	expected := map[string]map[string]string{"Service": {"web": "create", "api": "update"}}
+	if !reflect.DeepEqual(expected, w.pending) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, w.pending)
+	}
+
+	// planned changes replace each other, and are dropped once nothing's pending
+	err = w.delete(web)
+	if err != nil {
+		t.Errorf("Error planning delete: %v", err)
+	}
+	w.unchanged(api)
+	expected = map[string]map[string]string{"Service": {"web": "delete"}}
+	if !reflect.DeepEqual(expected, w.pending) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, w.pending)
+	}
+
+	// deleting an owner plans the deletion of its Ingress too
+	owner := manifests.NewOwner("prod")
+	err = w.delete(&owner)
+	if err != nil {
+		t.Errorf("Error planning delete: %v", err)
+	}
+	if operation := w.pending["Ingress"]["prod"]; operation != "delete" {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", "delete", operation)
+	}
+}
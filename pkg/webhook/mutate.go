@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	"github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// patchOperation is a JSON Patch operation
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// mutate rewrites `Service`s' config annotations in canonical form with the defaults filled in,
+// so that what's stored is what the controller builds from. Annotations that can't be read are left to validate
+func (server *Server) mutate(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	if request.Operation != admissionv1beta1.Create && request.Operation != admissionv1beta1.Update {
+		return allowed()
+	}
+	service := corev1.Service{}
+	err := json.Unmarshal(request.Object.Raw, &service)
+	if err != nil {
+		return allowed()
+	}
+	if service.ObjectMeta.Name == "" {
+		service.ObjectMeta.Name = request.Name
+	}
+	if service.ObjectMeta.Namespace == "" {
+		service.ObjectMeta.Namespace = request.Namespace
+	}
+	if !server.watched(service) {
+		return allowed()
+	}
+
+	err, normalized := manifests.NormalizeConfig(service, server.options.DefaultIngressName)
+	if err != nil || normalized == "" || normalized == service.ObjectMeta.Annotations[manifests.ConfigAnnotationKey] {
+		return allowed()
+	}
+	patch, err := json.Marshal([]patchOperation{{
+		Op:    "replace",
+		Path:  "/metadata/annotations/" + escapeJSONPointer(manifests.ConfigAnnotationKey),
+		Value: normalized,
+	}})
+	if err != nil {
+		logrus.Errorf("Failed to encode patch for Service '%s' : %v", service.Name, err)
+		return allowed()
+	}
+	logrus.Debugf("Normalized config annotation of Service '%s/%s'", request.Namespace, service.Name)
+
+	patchType := admissionv1beta1.PatchTypeJSONPatch
+	response := allowed()
+	response.Patch = patch
+	response.PatchType = &patchType
+
+	return response
+}
+
+// escapeJSONPointer escapes a JSON Pointer reference token, as annotation keys contain slashes
+func escapeJSONPointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMutate(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	web := newService("web", "path: /web//api\nhost: that.example.com")
+	web.Spec.Ports = []corev1.ServicePort{{Port: 80}}
+	response := review(t, server, "/mutate", admissionv1beta1.Create, web)
+	if !response.Allowed {
+		t.Errorf("Expected 'web' to be allowed, got %v", response.Result)
+	}
+	if response.PatchType == nil || *response.PatchType != admissionv1beta1.PatchTypeJSONPatch {
+		t.Errorf("Expected a JSON Patch, got %v", response.PatchType)
+	}
+	patch := []patchOperation{}
+	err := json.Unmarshal(response.Patch, &patch)
+	if err != nil {
+		t.Fatalf("Error decoding patch: %v", err)
+	}
+	expected := []patchOperation{{
+		Op:    "replace",
+		Path:  "/metadata/annotations/ingress-controller-controller.alpha.davidamick.com~1config",
+		Value: "apiVersion: v1beta1\nbackend:\n  port: 80\n  service: web\nhost: that.example.com\ningress: shared\npath: /web/api\n",
+	}}
+	if !reflect.DeepEqual(expected, patch) {
+		t.Errorf("Expected:\n%v\nGot:\n%v\n", expected, patch)
+	}
+
+	// already canonical
+	web = newService("web", expected[0].Value)
+	response = review(t, server, "/mutate", admissionv1beta1.Update, web)
+	if !response.Allowed || response.Patch != nil {
+		t.Errorf("Expected no patch, got %s", response.Patch)
+	}
+
+	// not watched by the controller
+	unwatched := newService("web", "path: /web//api\nhost: that.example.com")
+	unwatched.Labels = nil
+	response = review(t, server, "/mutate", admissionv1beta1.Create, unwatched)
+	if !response.Allowed || response.Patch != nil {
+		t.Errorf("Expected no patch, got %s", response.Patch)
+	}
+
+	// unreadable, left for validation to deny
+	invalid := newService("invalid", "name: [staging")
+	response = review(t, server, "/mutate", admissionv1beta1.Create, invalid)
+	if !response.Allowed || response.Patch != nil {
+		t.Errorf("Expected no patch, got %s", response.Patch)
+	}
+}
@@ -0,0 +1,160 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	"github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// validate denies `Service`s whose config annotation the controller would reject,
+// either as invalid, as breaking the host policy, or as routing a host and path another `Service` already routes.
+// Updates are only denied for what they change, so that a `Service` can still be edited, and its finalizer removed,
+// while it has problems or conflicts it already had, and `Service`s being deleted are always allowed
+func (server *Server) validate(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	if request.Operation != admissionv1beta1.Create && request.Operation != admissionv1beta1.Update {
+		return allowed()
+	}
+	err, service := decodeService(request.Object.Raw, request.Namespace)
+	if err != nil {
+		return denied(fmt.Sprintf("Failed to decode Service: %v", err))
+	}
+	if !server.watched(service) || service.ObjectMeta.DeletionTimestamp != nil {
+		return allowed()
+	}
+
+	err, messages := server.findings(service)
+	if err != nil {
+		logrus.Errorf("Failed to check Service '%s' for conflicts : %v", service.Name, err)
+		return denied(fmt.Sprintf("Failed to check for conflicts: %v", err))
+	}
+	if request.Operation == admissionv1beta1.Update && len(messages) > 0 {
+		err, old := decodeService(request.OldObject.Raw, request.Namespace)
+		if err != nil {
+			return denied(fmt.Sprintf("Failed to decode old Service: %v", err))
+		}
+		if server.watched(old) {
+			err, oldMessages := server.findings(old)
+			if err != nil {
+				logrus.Errorf("Failed to check Service '%s' for conflicts : %v", old.Name, err)
+				return denied(fmt.Sprintf("Failed to check for conflicts: %v", err))
+			}
+			messages = added(messages, oldMessages)
+		}
+	}
+	if len(messages) > 0 {
+		logrus.Infof("Denied Service '%s/%s' : %s", service.Namespace, service.Name, strings.Join(messages, "; "))
+		return denied("invalid ingress-controller-controller config annotation: " + strings.Join(messages, "; "))
+	}
+
+	return allowed()
+}
+
+// decodeService decodes a `Service` from an admission request, in the request's namespace if it has none
+func decodeService(raw []byte, namespace string) (error, corev1.Service) {
+	service := corev1.Service{}
+	err := json.Unmarshal(raw, &service)
+	if err != nil {
+		return err, service
+	}
+	if service.ObjectMeta.Namespace == "" {
+		service.ObjectMeta.Namespace = namespace
+	}
+
+	return nil, service
+}
+
+// findings returns why the controller would reject the `Service`'s config annotation, if it would
+func (server *Server) findings(service corev1.Service) (error, []string) {
+	messages := []string{}
+	for _, problem := range manifests.ValidateService(service, server.options.Build) {
+		messages = append(messages, problem.Error())
+	}
+	if len(messages) > 0 {
+		return nil, messages
+	}
+	err, violations, conflicts := server.check(service)
+	if err != nil {
+		return err, messages
+	}
+	for _, violation := range violations {
+		messages = append(messages, violation.Error())
+	}
+	for _, conflict := range conflicts {
+		messages = append(messages, conflict.Error())
+	}
+
+	return nil, messages
+}
+
+// added returns the messages that aren't in previous
+func added(messages, previous []string) []string {
+	seen := map[string]bool{}
+	for _, message := range previous {
+		seen[message] = true
+	}
+	result := []string{}
+	for _, message := range messages {
+		if !seen[message] {
+			result = append(result, message)
+		}
+	}
+
+	return result
+}
+
+// check finds the host policy violations of the `Service`, and the conflicts it would be part of if it were admitted,
+// whether it would lose a route or take one from an existing `Service`.
+// Like in the controller, the routes breaking the policy don't take part in conflicts
+func (server *Server) check(service corev1.Service) (error, []manifests.Violation, []manifests.Conflict) {
+	violations := []manifests.Violation{}
+	conflicts := []manifests.Conflict{}
+	if len(manifests.GetAnnotatedServices(corev1.ServiceList{Items: []corev1.Service{service}}).Items) == 0 {
+		return nil, violations, conflicts
+	}
+	policy := manifests.HostPolicy{}
+	if server.policies != nil {
+		var err error
+		err, policy = manifests.GetHostPolicy(server.policies)
+		if err != nil {
+			return fmt.Errorf("invalid host policy ConfigMap '%s': %v", manifests.HostPolicyName, err), violations, conflicts
+		}
+	}
+	services := corev1.ServiceList{}
+	if server.services != nil {
+		err, existing := manifests.GetAllServices(server.services)
+		if err != nil {
+			return err, violations, conflicts
+		}
+		for _, item := range existing.Items {
+			if item.ObjectMeta.Namespace != service.ObjectMeta.Namespace || item.ObjectMeta.Name != service.ObjectMeta.Name {
+				services.Items = append(services.Items, item)
+			}
+		}
+	}
+	services.Items = append(services.Items, service)
+	services = manifests.ExcludeDeletingServices(manifests.GetAnnotatedServices(services))
+	valid, _ := manifests.ValidServices(services, server.options.Build)
+
+	allViolations := manifests.CheckHostPolicy(valid, policy, server.options.Build)
+	for _, violation := range allViolations {
+		if violation.Namespace == service.ObjectMeta.Namespace && violation.Service == service.ObjectMeta.Name {
+			violations = append(violations, violation)
+		}
+	}
+
+	for _, conflict := range manifests.FindConflicts(valid, allViolations, server.options.Build) {
+		lost := conflict.Namespace == service.ObjectMeta.Namespace && conflict.Service == service.ObjectMeta.Name
+		taken := conflict.ClaimedNamespace == service.ObjectMeta.Namespace && conflict.ClaimedService == service.ObjectMeta.Name
+		if lost || taken {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	return nil, violations, conflicts
+}
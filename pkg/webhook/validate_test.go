@@ -0,0 +1,304 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newService(name, config string) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"icc-operator": "true"},
+			Annotations: map[string]string{
+				"ingress-controller-controller.alpha.davidamick.com/config": config,
+			},
+		},
+	}
+}
+
+// newTestServer serves the webhooks over HTTPS, with the `Service`s as the cluster's
+func newTestServer(services ...*corev1.Service) *httptest.Server {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		manifests.IngressNameIndex: manifests.IngressNameIndexFunc,
+	})
+	for _, service := range services {
+		indexer.Add(service)
+	}
+
+	return httptest.NewTLSServer(NewServer(corelisters.NewServiceLister(indexer).Services("default"), nil, Options{
+		DefaultIngressName: "shared",
+		Namespace:          "default",
+		Selector:           labels.SelectorFromSet(labels.Set{"icc-operator": "true"}),
+	}))
+}
+
+// review posts an `AdmissionReview` of the `Service` to the path
+func review(t *testing.T, server *httptest.Server, path string, operation admissionv1beta1.Operation, service *corev1.Service) *admissionv1beta1.AdmissionResponse {
+	return reviewUpdate(t, server, path, operation, service, nil)
+}
+
+// reviewUpdate posts an `AdmissionReview` of the `Service` to the path, with what it was before if old isn't nil
+func reviewUpdate(t *testing.T, server *httptest.Server, path string, operation admissionv1beta1.Operation, service, old *corev1.Service) *admissionv1beta1.AdmissionResponse {
+	raw, err := json.Marshal(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldObject := runtime.RawExtension{}
+	if old != nil {
+		oldObject.Raw, err = json.Marshal(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	body, err := json.Marshal(admissionv1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "AdmissionReview",
+			APIVersion: "admission.k8s.io/v1beta1",
+		},
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "review-uid",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+			Name:      service.Name,
+			Namespace: "default",
+			Operation: operation,
+			Object:    runtime.RawExtension{Raw: raw},
+			OldObject: oldObject,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := server.Client().Post(server.URL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", response.StatusCode)
+	}
+	result := admissionv1beta1.AdmissionReview{}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Response == nil || result.Response.UID != "review-uid" {
+		t.Fatalf("Expected a response to review-uid, got %v", result.Response)
+	}
+
+	return result.Response
+}
+
+func TestValidate(t *testing.T) {
+	web := newService("web", "name: staging\nhost: that.example.com\npath: /that\nservice: web\nport: 80")
+	web.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+	server := newTestServer(web)
+	defer server.Close()
+
+	// a new route
+	api := newService("api", "name: staging\nhost: that.example.com\npath: /api\nservice: api\nport: 80")
+	if response := review(t, server, "/validate", admissionv1beta1.Create, api); !response.Allowed {
+		t.Errorf("Expected 'api' to be allowed, got %v", response.Result)
+	}
+
+	// not annotated
+	plain := newService("plain", "")
+	if response := review(t, server, "/validate", admissionv1beta1.Create, plain); !response.Allowed {
+		t.Errorf("Expected 'plain' to be allowed, got %v", response.Result)
+	}
+
+	// updating a Service's own route
+	moved := newService("web", "name: staging\nhost: that.example.com\npath: /moved\nservice: web\nport: 80")
+	moved.CreationTimestamp = web.CreationTimestamp
+	if response := review(t, server, "/validate", admissionv1beta1.Update, moved); !response.Allowed {
+		t.Errorf("Expected 'web' to be allowed, got %v", response.Result)
+	}
+
+	// invalid
+	invalid := newService("invalid", "name: staging\nhost: that.example.com\npath: that\nservice: invalid")
+	response := review(t, server, "/validate", admissionv1beta1.Create, invalid)
+	if response.Allowed {
+		t.Errorf("Expected 'invalid' to be denied")
+	}
+	for _, expected := range []string{"path: must begin with '/'", "port: must be between 1 and 65535"} {
+		if response.Result == nil || !strings.Contains(response.Result.Message, expected) {
+			t.Errorf("Expected message to contain %q, got %v", expected, response.Result)
+		}
+	}
+
+	// not watched by the controller, so left alone however invalid
+	unwatched := invalid.DeepCopy()
+	unwatched.Labels = nil
+	if response := review(t, server, "/validate", admissionv1beta1.Create, unwatched); !response.Allowed {
+		t.Errorf("Expected a Service without the label to be allowed, got %v", response.Result)
+	}
+	unwatched = invalid.DeepCopy()
+	unwatched.Namespace = "other"
+	if response := review(t, server, "/validate", admissionv1beta1.Create, unwatched); !response.Allowed {
+		t.Errorf("Expected a Service in another namespace to be allowed, got %v", response.Result)
+	}
+
+	// a route already taken, even from another Ingress
+	copycat := newService("copycat", "name: production\nhost: that.example.com\npath: /that\nservice: copycat\nport: 80")
+	response = review(t, server, "/validate", admissionv1beta1.Create, copycat)
+	if response.Allowed {
+		t.Errorf("Expected 'copycat' to be denied")
+	}
+	expected := "already routed by default/web for Ingress 'staging'"
+	if response.Result == nil || !strings.Contains(response.Result.Message, expected) {
+		t.Errorf("Expected message to contain %q, got %v", expected, response.Result)
+	}
+
+	// moving the Ingress to another class
+	classy := newService("classy", "apiVersion: v1beta1\ningress: staging\nhost: classy.example.com\ningressClass: internal\nbackend:\n  service: classy\n  port: 80")
+	response = review(t, server, "/validate", admissionv1beta1.Create, classy)
+	expected = "class 'internal' for Ingress 'staging' differs from none set by default/web"
+	if response.Allowed || response.Result == nil || !strings.Contains(response.Result.Message, expected) {
+		t.Errorf("Expected 'classy' to be denied with %q, got %v", expected, response.Result)
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	web := newService("web", "name: staging\nhost: that.example.com\npath: /that\nservice: web\nport: 80")
+	web.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+	// newer, so it loses the route, but was admitted before the webhook was registered
+	copycat := newService("copycat", "name: production\nhost: that.example.com\npath: /that\nservice: copycat\nport: 80")
+	copycat.CreationTimestamp = metav1.NewTime(time.Unix(200, 0))
+	invalid := newService("invalid", "name: staging\nhost: that.example.com\npath: that\nservice: invalid")
+	server := newTestServer(web, copycat, invalid)
+	defer server.Close()
+
+	// conflicts and problems the Service already had don't stop other changes to it
+	for _, service := range []*corev1.Service{web, copycat, invalid} {
+		labeled := service.DeepCopy()
+		labeled.Labels["team"] = "web"
+		if response := reviewUpdate(t, server, "/validate", admissionv1beta1.Update, labeled, service); !response.Allowed {
+			t.Errorf("Expected the label change to '%s' to be allowed, got %v", service.Name, response.Result)
+		}
+	}
+
+	// but new ones do
+	broken := newService("web", "name: staging\nhost: that.example.com\npath: /that\nservice: web\nport: 70000")
+	broken.CreationTimestamp = web.CreationTimestamp
+	if response := reviewUpdate(t, server, "/validate", admissionv1beta1.Update, broken, web); response.Allowed {
+		t.Errorf("Expected the invalid change to 'web' to be denied")
+	}
+	taken := newService("api", "name: staging\nhost: that.example.com\npath: /api\nservice: api\nport: 80")
+	copied := newService("api", "name: production\nhost: that.example.com\npath: /that\nservice: api\nport: 80")
+	if response := reviewUpdate(t, server, "/validate", admissionv1beta1.Update, copied, taken); response.Allowed {
+		t.Errorf("Expected the conflicting change to 'api' to be denied")
+	}
+
+	// being deleted, such as our finalizer being removed
+	deleting := invalid.DeepCopy()
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	deleting.Annotations[manifests.ConfigAnnotationKey] = "name: Nope"
+	if response := reviewUpdate(t, server, "/validate", admissionv1beta1.Update, deleting, invalid); !response.Allowed {
+		t.Errorf("Expected the deleting Service to be allowed, got %v", response.Result)
+	}
+}
+
+func TestValidateHostPolicy(t *testing.T) {
+	// older, so it would win the conflict if it were allowed the host
+	hijack := newService("hijack", "name: production\nhost: that.example.com\npath: /that\nservice: hijack\nport: 80")
+	hijack.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+	services := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		manifests.IngressNameIndex: manifests.IngressNameIndexFunc,
+	})
+	services.Add(hijack)
+	policies := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	policies.Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: manifests.HostPolicyName, Namespace: "default"},
+		Data: map[string]string{"policy.yaml": `
+rules:
+- host: .example.com
+  serviceSelector: team=web
+namespaceRules:
+- tlsSecrets: [web-*]
+`},
+	})
+	server := httptest.NewTLSServer(NewServer(
+		corelisters.NewServiceLister(services).Services("default"),
+		corelisters.NewConfigMapLister(policies).ConfigMaps("default"),
+		Options{},
+	))
+	defer server.Close()
+
+	web := newService("web", "apiVersion: v1beta1\ningress: staging\nhost: that.example.com\npath: /that\ntls:\n  secretName: web-tls\nbackend:\n  service: web\n  port: 80")
+	web.Labels = map[string]string{"team": "web"}
+	if response := review(t, server, "/validate", admissionv1beta1.Create, web); !response.Allowed {
+		t.Errorf("Expected 'web' to be allowed, got %v", response.Result)
+	}
+
+	for name, expected := range map[string]string{
+		"other":  "host 'that.example.com' for Ingress 'staging' isn't allowed for Services not matching 'team=web'",
+		"secret": "TLS Secret 'api-tls' for Ingress 'staging' isn't allowed for namespace 'default'",
+	} {
+		service := web.DeepCopy()
+		service.Name = name
+		if name == "other" {
+			service.Labels = nil
+		} else {
+			service.Annotations[manifests.ConfigAnnotationKey] = strings.Replace(service.Annotations[manifests.ConfigAnnotationKey], "web-tls", "api-tls", 1)
+		}
+		response := review(t, server, "/validate", admissionv1beta1.Create, service)
+		if response.Allowed {
+			t.Errorf("Expected '%s' to be denied", name)
+		}
+		if response.Result == nil || !strings.Contains(response.Result.Message, expected) {
+			t.Errorf("Expected message to contain %q, got %v", expected, response.Result)
+		}
+	}
+
+	policies.Update(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: manifests.HostPolicyName, Namespace: "default"},
+		Data:       map[string]string{"policy.yaml": "rules: nope"},
+	})
+	if response := review(t, server, "/validate", admissionv1beta1.Create, web); response.Allowed {
+		t.Errorf("Expected 'web' to be denied while the host policy is invalid")
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	response, err := server.Client().Post(server.URL+"/validate", "application/json", strings.NewReader("not json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.StatusCode)
+	}
+
+	response, err = server.Client().Get(server.URL + "/validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", response.StatusCode)
+	}
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/snarlysodboxer/ingress-controller-controller/pkg/manifests"
+
+	"github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// Options configure the webhooks
+type Options struct {
+	// DefaultIngressName is filled in for config annotations that don't name an `Ingress`, unless it's empty
+	DefaultIngressName string
+	// Namespace is the namespace the controller watches, `Service`s in others are admitted untouched. Empty for any
+	Namespace string
+	// Selector matches the `Service`s the controller watches, others are admitted untouched. Nil for any
+	Selector labels.Selector
+	// Build is how the controller builds `Ingress`s from the annotations
+	Build manifests.Options
+}
+
+// Server answers admission reviews of `Service`s
+type Server struct {
+	// services are the `Service`s the controller sees, which new routes must not conflict with
+	services corelisters.ServiceNamespaceLister
+	// policies holds the host policy `ConfigMap` new routes must follow, if there is one
+	policies corelisters.ConfigMapNamespaceLister
+	options  Options
+	mux      *http.ServeMux
+}
+
+// admitFunc decides on an admission request
+type admitFunc func(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse
+
+// NewServer builds a Server checking routes against the `Service`s and the host policy in the listers
+func NewServer(services corelisters.ServiceNamespaceLister, policies corelisters.ConfigMapNamespaceLister, o Options) *Server {
+	server := &Server{
+		services: services,
+		policies: policies,
+		options:  o,
+		mux:      http.NewServeMux(),
+	}
+	server.mux.HandleFunc("/validate", server.serve(server.validate))
+	server.mux.HandleFunc("/mutate", server.serve(server.mutate))
+
+	return server
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServeTLS serves the webhooks on the address until it fails
+func (server *Server) ListenAndServeTLS(address, certFile, keyFile string) error {
+	logrus.Infof("Serving admission webhooks on %s", address)
+	return http.ListenAndServeTLS(address, certFile, keyFile, server)
+}
+
+// serve decodes an `AdmissionReview`, and responds with the decision of admit
+func (server *Server) serve(admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "expected a POST", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review := admissionv1beta1.AdmissionReview{}
+		err = json.Unmarshal(body, &review)
+		if err != nil || review.Request == nil {
+			http.Error(w, fmt.Sprintf("expected an AdmissionReview: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		response := admit(review.Request)
+		response.UID = review.Request.UID
+		out, err := json.Marshal(admissionv1beta1.AdmissionReview{
+			TypeMeta: review.TypeMeta,
+			Response: response,
+		})
+		if err != nil {
+			logrus.Errorf("Failed to encode AdmissionReview for '%s' : %v", review.Request.Name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
+
+// watched reports whether the controller watches the `Service`, the webhooks leave others alone
+func (server *Server) watched(service corev1.Service) bool {
+	if server.options.Namespace != "" && service.ObjectMeta.Namespace != server.options.Namespace {
+		return false
+	}
+
+	return server.options.Selector == nil || server.options.Selector.Matches(labels.Set(service.ObjectMeta.Labels))
+}
+
+func allowed() *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{Allowed: true}
+}
+
+func denied(message string) *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: message,
+			Code:    http.StatusUnprocessableEntity,
+		},
+	}
+}